@@ -0,0 +1,53 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package audit
+
+import (
+	"seif/auth"
+	"seif/db_ops"
+	"seif/logging"
+	"seif/params"
+	"seif/proxy"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The audit event for a request, with the client's IP and principal
+func Event(c *fiber.Ctx, event, id string) db_ops.AuditEvent {
+	e := db_ops.AuditEvent{Event: event, Id: id, Ip: proxy.ClientIP(c)}
+	if p := auth.FromCtx(c); p != nil {
+		e.Principal = p.Method + ":" + p.Name
+	}
+	return e
+}
+
+// Counts the event for a request, for the events that anyone can cause at
+// will, as rate-limited requests; see db_ops.CountAudit. The client is
+// recorded, not the secret.
+func Count(c *fiber.Ctx, event string) {
+	db_ops.CountAudit(Event(c, event, ""))
+}
+
+// Appends the event for a request to the audit log; the caller holds
+// params.Lock. Errors are only reported, they don't fail the request.
+func LogLocked(c *fiber.Ctx, event, id string) {
+	if err := db_ops.AppendAudit(params.Db, Event(c, event, id)); err != nil {
+		logging.FromCtx(c).Error("audit failed", "event", event, "error", err)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package auth
+
+import (
+	"seif/db_ops"
+	"seif/params"
+	"seif/proxy"
+	"seif/utils"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Who made the request, as established by one of the authentication methods
+type Principal struct {
+	Method string `json:"method"`          // "token", "htpasswd" or "oidc"
+	Name   string `json:"name"`            // for OIDC, the subject
+	Label  string `json:"label,omitempty"` // to show, e.g. the email of an OIDC user; never to identify
+}
+
+const localsKey = "principal"
+
+// Authentication is enabled if at least one method is configured
+func Enabled() bool {
+	return params.AuthTokensFile != "" || params.AuthDbTokens || params.AuthHtpasswd != "" || params.OidcIssuer != ""
+}
+
+// Loads the configured methods. Aborts on errors, as it's called at startup.
+func Init() {
+	if params.AuthTokensFile != "" {
+		if err := loadTokensFile(params.AuthTokensFile); err != nil {
+			utils.Abort("in loading tokens file: %s", err)
+		}
+	}
+	if params.AuthHtpasswd != "" {
+		if err := loadHtpasswd(params.AuthHtpasswd); err != nil {
+			utils.Abort("in loading htpasswd file: %s", err)
+		}
+	}
+	if params.OidcIssuer != "" {
+		if err := initOidc(); err != nil {
+			utils.Abort("in setting up OIDC: %s", err)
+		}
+	}
+}
+
+// Returns the principal of the request, or nil if anonymous
+func FromCtx(c *fiber.Ctx) *Principal {
+	if p, ok := c.Locals(localsKey).(*Principal); ok {
+		return p
+	}
+	return nil
+}
+
+// Middleware that lets only authenticated requests through, if auth is enabled
+func Required(c *fiber.Ctx) error {
+	if !Enabled() {
+		return c.Next()
+	}
+
+	p, err := authenticate(c)
+	if err != nil {
+		return utils.SendError(c, utils.FHE008, "authentication", &err)
+	}
+	if p == nil {
+		// Only wrong credentials are worth auditing, not missing ones; they
+		// are counted, as anyone can make up as many as they like
+		if c.Get(fiber.HeaderAuthorization) != "" {
+			db_ops.CountAudit(db_ops.AuditEvent{Event: db_ops.AUDIT_AUTH_FAILED, Ip: proxy.ClientIP(c)})
+		}
+
+		if params.AuthHtpasswd != "" {
+			// Makes browsers ask for credentials
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="seif", charset="UTF-8"`)
+		} else {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="seif"`)
+		}
+		return utils.SendError(c, utils.FHE012, "", nil)
+	}
+
+	c.Locals(localsKey, p)
+	return c.Next()
+}
+
+// Middleware that sets the principal if the request has valid credentials,
+// letting all requests through; for the pages that only adapt to it
+func Optional(c *fiber.Ctx) error {
+	if Enabled() {
+		if p, _ := authenticate(c); p != nil {
+			c.Locals(localsKey, p)
+		}
+	}
+	return c.Next()
+}
+
+// Middleware that lets only admin users through; must come after Required.
+// The admin API is available only if auth is enabled. Admins are listed
+// with their method, so that e.g. an OIDC user can't pass for a token with
+// the same name, and OIDC users by their subject.
+func Admin(c *fiber.Ctx) error {
+	p := FromCtx(c)
+	if p == nil || !slices.Contains(params.AdminUsers, p.Method+":"+p.Name) {
+		return utils.SendError(c, utils.FHE016, "", nil)
+	}
+	return c.Next()
+}
+
+func authenticate(c *fiber.Ctx) (*Principal, error) {
+	scheme, cred, _ := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	switch {
+	case strings.EqualFold(scheme, "Bearer"):
+		if p, err := checkToken(cred); p != nil || err != nil {
+			return p, err
+		}
+		if oidcVerifier != nil {
+			return checkBearerJwt(c.Context(), cred), nil
+		}
+		return nil, nil
+	case strings.EqualFold(scheme, "Basic"):
+		return checkBasic(cred), nil
+	}
+	if oidcVerifier != nil {
+		return checkSession(c), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package auth
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"seif/db_ops"
+	"seif/params"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Opens a fresh db for the test, closing it at the end
+func openTestDb(t *testing.T) {
+	t.Helper()
+	params.DbPath = filepath.Join(t.TempDir(), "seif.db")
+	db_ops.Open()
+	t.Cleanup(func() { params.Db.Close() })
+}
+
+func TestAdmin(t *testing.T) {
+	params.AdminUsers = []string{"token:ci", "htpasswd:bob"}
+	t.Cleanup(func() { params.AdminUsers = nil })
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		if p := c.Get("X-Principal"); p != "" {
+			method, name, _ := strings.Cut(p, ":")
+			c.Locals(localsKey, &Principal{Method: method, Name: name})
+		}
+		return c.Next()
+	}, Admin, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for principal, status := range map[string]int{
+		"token:ci":     fiber.StatusOK,
+		"htpasswd:bob": fiber.StatusOK,
+		"oidc:ci":      fiber.StatusForbidden,
+		"oidc:bob":     fiber.StatusForbidden,
+		"token:bob":    fiber.StatusForbidden,
+		"":             fiber.StatusForbidden,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Principal", principal)
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != status {
+			t.Errorf("%q: got %d, expected %d", principal, res.StatusCode, status)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// user -> hash, from the htpasswd file
+var htpasswd = map[string]string{}
+
+// Loads an htpasswd file. Only bcrypt ("htpasswd -B") and SHA1 ("htpasswd -s")
+// hashes are supported.
+func loadHtpasswd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		txt := strings.TrimSpace(scanner.Text())
+		if txt == "" || strings.HasPrefix(txt, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(txt, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("line %d is malformed", line)
+		}
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return fmt.Errorf("line %d: unsupported hash, use bcrypt or SHA1", line)
+		}
+		htpasswd[user] = hash
+	}
+	return scanner.Err()
+}
+
+func checkBasic(cred string) *Principal {
+	bs, err := base64.StdEncoding.DecodeString(cred)
+	if err != nil {
+		return nil
+	}
+	user, pass, ok := strings.Cut(string(bs), ":")
+	if !ok {
+		return nil
+	}
+
+	hash, ok := htpasswd[user]
+	if !ok {
+		return nil
+	}
+
+	if strings.HasPrefix(hash, "{SHA}") {
+		h := sha1.Sum([]byte(pass))
+		if subtle.ConstantTimeCompare([]byte(hash[5:]), []byte(base64.StdEncoding.EncodeToString(h[:]))) != 1 {
+			return nil
+		}
+	} else if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+		return nil
+	}
+
+	return &Principal{Method: "htpasswd", Name: user}
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func basic(user, pass string) string {
+	return base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+}
+
+func TestHtpasswd(t *testing.T) {
+	htpasswd = map[string]string{}
+	t.Cleanup(func() { htpasswd = map[string]string{} })
+
+	bc, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh := sha1.Sum([]byte("sha-pass"))
+	content := "# users\nalice:" + string(bc) + "\nbob:{SHA}" + base64.StdEncoding.EncodeToString(sh[:]) + "\n"
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadHtpasswd(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ok := range []struct{ user, pass string }{{"alice", "bcrypt-pass"}, {"bob", "sha-pass"}} {
+		p := checkBasic(basic(ok.user, ok.pass))
+		if p == nil || *p != (Principal{Method: "htpasswd", Name: ok.user}) {
+			t.Errorf("%s: got %v", ok.user, p)
+		}
+	}
+
+	for _, ko := range []string{
+		basic("alice", "wrong"),
+		basic("alice", "sha-pass"),
+		basic("bob", "wrong"),
+		basic("bob", "bcrypt-pass"),
+		basic("carol", "bcrypt-pass"),
+		basic("alice", ""),
+		base64.StdEncoding.EncodeToString([]byte("alice")),
+		"not base64!",
+	} {
+		if p := checkBasic(ko); p != nil {
+			t.Errorf("%q: should fail, got %v", ko, p)
+		}
+	}
+}
+
+func TestHtpasswdUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	for _, bad := range []string{"alice:$apr1$abc$def\n", "alice:plain\n", "alice\n", ":$2y$05$abc\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadHtpasswd(path); err == nil {
+			t.Errorf("%q: should be refused", bad)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"seif/db_ops"
+	"seif/params"
+	"seif/utils"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/oauth2"
+)
+
+const sessionCookie = "seif_session"
+const loginCookie = "seif_login"
+
+const sessionDuration = 12 * time.Hour
+const loginDuration = 10 * time.Minute
+
+var oidcConfig oauth2.Config
+var oidcVerifier *oidc.IDTokenVerifier
+
+// Pending login, between the redirect to the IdP and the callback
+type login struct {
+	State    string `json:"s"`
+	Verifier string `json:"v"`
+	Nonce    string `json:"n"`
+	Exp      int64  `json:"e"`
+}
+
+type session struct {
+	Name  string `json:"n"`
+	Label string `json:"l,omitempty"`
+	Exp   int64  `json:"e"`
+}
+
+func initOidc() error {
+	if params.OidcClientId == "" || params.OidcRedirectUrl == "" {
+		return errors.New("client id and redirect url are mandatory")
+	}
+
+	var err error
+	if sessionKey, err = db_ops.GetKey("session_key", 32); err != nil {
+		return err
+	}
+
+	provider, err := oidc.NewProvider(context.Background(), params.OidcIssuer)
+	if err != nil {
+		return err
+	}
+
+	oidcConfig = oauth2.Config{
+		ClientID:     params.OidcClientId,
+		ClientSecret: params.OidcClientSecret,
+		RedirectURL:  params.OidcRedirectUrl,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+	oidcVerifier = provider.Verifier(&oidc.Config{ClientID: params.OidcClientId})
+	return nil
+}
+
+func randomString() (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+func setCookie(c *fiber.Ctx, name, value string, exp time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  exp,
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// The principal of an ID token. It's identified by the subject, that the
+// IdP assigns; the email (if the IdP verified it) or the username are only
+// a label to show, as users can often choose them.
+func principalFromToken(idToken *oidc.IDToken) *Principal {
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified any    `json:"email_verified"` // some IdPs send it as a string
+		Username      string `json:"preferred_username"`
+	}
+	p := &Principal{Method: "oidc", Name: idToken.Subject, Label: idToken.Subject}
+	if err := idToken.Claims(&claims); err == nil {
+		if claims.Email != "" && claims.EmailVerified == true {
+			p.Label = claims.Email
+		} else if claims.Username != "" {
+			p.Label = claims.Username
+		}
+	}
+	return p
+}
+
+// Starts the authorization code flow (with PKCE), redirecting to the IdP
+func Login(c *fiber.Ctx) error {
+	var l login
+	var err error
+	if l.State, err = randomString(); err == nil {
+		if l.Nonce, err = randomString(); err == nil {
+			l.Verifier = oauth2.GenerateVerifier()
+		}
+	}
+	if err != nil {
+		return utils.SendError(c, utils.FHE007, "", &err)
+	}
+
+	exp := time.Now().Add(loginDuration)
+	l.Exp = exp.Unix()
+	sealed, err := seal(l)
+	if err != nil {
+		return utils.SendError(c, utils.FHE008, "sealing", &err)
+	}
+	setCookie(c, loginCookie, sealed, exp)
+
+	return c.Redirect(oidcConfig.AuthCodeURL(l.State, oidc.Nonce(l.Nonce), oauth2.S256ChallengeOption(l.Verifier)))
+}
+
+func Callback(c *fiber.Ctx) error {
+	var l login
+	if !unseal(c.Cookies(loginCookie), &l) || l.Exp < time.Now().Unix() || c.Query("state") != l.State {
+		return utils.SendError(c, utils.FHE013, "", nil)
+	}
+	setCookie(c, loginCookie, "", time.Unix(0, 0))
+
+	if e := c.Query("error"); e != "" {
+		err := errors.New(e + ": " + c.Query("error_description"))
+		return utils.SendError(c, utils.FHE013, "", &err)
+	}
+
+	token, err := oidcConfig.Exchange(c.Context(), c.Query("code"), oauth2.VerifierOption(l.Verifier))
+	if err != nil {
+		return utils.SendError(c, utils.FHE013, "", &err)
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		err = errors.New("no id_token in response")
+		return utils.SendError(c, utils.FHE013, "", &err)
+	}
+	idToken, err := oidcVerifier.Verify(c.Context(), rawIdToken)
+	if err != nil {
+		return utils.SendError(c, utils.FHE013, "", &err)
+	}
+	if idToken.Nonce != l.Nonce {
+		err = errors.New("nonce mismatch")
+		return utils.SendError(c, utils.FHE013, "", &err)
+	}
+
+	exp := time.Now().Add(sessionDuration)
+	p := principalFromToken(idToken)
+	sealed, err := seal(session{Name: p.Name, Label: p.Label, Exp: exp.Unix()})
+	if err != nil {
+		return utils.SendError(c, utils.FHE008, "sealing", &err)
+	}
+	setCookie(c, sessionCookie, sealed, exp)
+
+	return c.Redirect("/")
+}
+
+// A POST, so that it can't be triggered by a link or an image on another site
+func Logout(c *fiber.Ctx) error {
+	setCookie(c, sessionCookie, "", time.Unix(0, 0))
+	return c.Redirect("/", fiber.StatusSeeOther)
+}
+
+func checkSession(c *fiber.Ctx) *Principal {
+	var s session
+	if !unseal(c.Cookies(sessionCookie), &s) || s.Exp < time.Now().Unix() {
+		return nil
+	}
+	return &Principal{Method: "oidc", Name: s.Name, Label: s.Label}
+}
+
+// For API clients that got an ID token from the IdP by themselves
+func checkBearerJwt(ctx context.Context, raw string) *Principal {
+	idToken, err := oidcVerifier.Verify(ctx, raw)
+	if err != nil {
+		return nil
+	}
+	return principalFromToken(idToken)
+}
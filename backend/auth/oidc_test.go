@@ -0,0 +1,376 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"seif/params"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testClientId = "seif-test"
+
+// A minimal IdP: discovery, authorization (that logs in at once), token
+// exchange with PKCE, and keys
+type mockIdp struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any // added to the ID token
+
+	mu     sync.Mutex
+	codes  map[string]url.Values // code -> the authorization request
+	nonces []string
+}
+
+func newMockIdp(t *testing.T) *mockIdp {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIdp{key: key, codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	mux.HandleFunc("/keys", m.keys)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockIdp) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                m.URL,
+		"authorization_endpoint":                m.URL + "/authorize",
+		"token_endpoint":                        m.URL + "/token",
+		"jwks_uri":                              m.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (m *mockIdp) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	code := "code-" + q.Get("state")
+	m.mu.Lock()
+	m.codes[code] = q
+	m.mu.Unlock()
+	redirect, _ := url.Parse(q.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *mockIdp) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	m.mu.Lock()
+	auth, ok := m.codes[r.Form.Get("code")]
+	delete(m.codes, r.Form.Get("code"))
+	m.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || auth.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":"invalid_grant"}`)
+		return
+	}
+
+	claims := map[string]any{
+		"iss":   m.URL,
+		"aud":   testClientId,
+		"sub":   "subject-1",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": auth.Get("nonce"),
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     m.sign(claims),
+	})
+}
+
+func (m *mockIdp) keys(w http.ResponseWriter, r *http.Request) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"alg": "RS256",
+		"use": "sig",
+		"kid": "k1",
+		"n":   b64(m.key.N.Bytes()),
+		"e":   b64(big.NewInt(int64(m.key.E)).Bytes()),
+	}}})
+}
+
+func (m *mockIdp) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	data := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	h := sha256.Sum256([]byte(data))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, h[:])
+	if err != nil {
+		panic(err)
+	}
+	return data + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func setupOidc(t *testing.T, idp *mockIdp) *fiber.App {
+	t.Helper()
+	openTestDb(t)
+	params.OidcIssuer = idp.URL
+	params.OidcClientId = testClientId
+	params.OidcClientSecret = "secret"
+	params.OidcRedirectUrl = "http://seif.test/auth/callback"
+	t.Cleanup(func() {
+		params.OidcIssuer, params.OidcClientId, params.OidcClientSecret, params.OidcRedirectUrl = "", "", "", ""
+		oidcVerifier = nil
+	})
+	if err := initOidc(); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/auth/login", Login)
+	app.Get("/auth/callback", Callback)
+	app.Post("/auth/logout", Logout)
+	app.Get("/whoami", Required, func(c *fiber.Ctx) error {
+		p := FromCtx(c)
+		return c.SendString(p.Name + " " + p.Label)
+	})
+	app.Get("/admin", Required, Admin, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	return app
+}
+
+func cookie(res *http.Response, name string) *http.Cookie {
+	for _, c := range res.Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Goes through the whole code flow, returning the response to the callback
+func codeFlow(t *testing.T, app *fiber.App, tamper func(*url.URL)) *http.Response {
+	t.Helper()
+	res, err := app.Test(httptest.NewRequest("GET", "/auth/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusFound {
+		t.Fatalf("login: status %d", res.StatusCode)
+	}
+	login := cookie(res, loginCookie)
+	if login == nil {
+		t.Fatal("login: no cookie")
+	}
+
+	// The browser goes to the IdP, that redirects back at once
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	idpRes, err := client.Get(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idpRes.Body.Close()
+	callback, err := url.Parse(idpRes.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		tamper(callback)
+	}
+
+	req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+	req.AddCookie(&http.Cookie{Name: loginCookie, Value: login.Value})
+	res, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func whoami(t *testing.T, app *fiber.App, session string) (int, string) {
+	return get(t, app, "/whoami", session)
+}
+
+func get(t *testing.T, app *fiber.App, path, session string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: session})
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(body)
+}
+
+func TestOidcCodeFlow(t *testing.T) {
+	idp := newMockIdp(t)
+	app := setupOidc(t, idp)
+
+	for _, tc := range []struct {
+		claims map[string]any
+		name   string
+	}{
+		{map[string]any{"email": "alice@example.com", "email_verified": true, "preferred_username": "alice"}, "subject-1 alice@example.com"},
+		{map[string]any{"email": "alice@example.com", "email_verified": false, "preferred_username": "mallory"}, "subject-1 mallory"},
+		{map[string]any{"email": "alice@example.com", "email_verified": "true", "preferred_username": "mallory"}, "subject-1 mallory"},
+		{map[string]any{"email": "alice@example.com"}, "subject-1 subject-1"},
+	} {
+		idp.claims = tc.claims
+		res := codeFlow(t, app, nil)
+		if res.StatusCode != fiber.StatusFound || res.Header.Get("Location") != "/" {
+			t.Fatalf("callback: status %d", res.StatusCode)
+		}
+		session := cookie(res, sessionCookie)
+		if session == nil {
+			t.Fatal("callback: no session cookie")
+		}
+		if status, name := whoami(t, app, session.Value); status != fiber.StatusOK || name != tc.name {
+			t.Errorf("claims %v: got %d %q, expected %q", tc.claims, status, name, tc.name)
+		}
+	}
+
+	if status, _ := whoami(t, app, ""); status != fiber.StatusUnauthorized {
+		t.Errorf("no session: got %d", status)
+	}
+
+	res, err := app.Test(httptest.NewRequest("POST", "/auth/logout", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cookie(res, sessionCookie); res.StatusCode != fiber.StatusSeeOther || c == nil || c.Value != "" {
+		t.Errorf("logout: got %d, %v", res.StatusCode, c)
+	}
+}
+
+// Admins are identified by the subject, not by the names users can choose
+func TestOidcAdmin(t *testing.T) {
+	idp := newMockIdp(t)
+	app := setupOidc(t, idp)
+	params.AdminUsers = []string{"oidc:alice@example.com", "oidc:subject-admin"}
+	t.Cleanup(func() { params.AdminUsers = nil })
+
+	for _, tc := range []struct {
+		claims map[string]any
+		status int
+	}{
+		{map[string]any{"preferred_username": "alice@example.com"}, fiber.StatusForbidden},
+		{map[string]any{"email": "alice@example.com", "email_verified": true}, fiber.StatusForbidden},
+		{map[string]any{"sub": "subject-admin", "preferred_username": "mallory"}, fiber.StatusOK},
+	} {
+		idp.claims = tc.claims
+		session := cookie(codeFlow(t, app, nil), sessionCookie)
+		if session == nil {
+			t.Fatal("callback: no session cookie")
+		}
+		if status, _ := get(t, app, "/admin", session.Value); status != tc.status {
+			t.Errorf("claims %v: got %d, expected %d", tc.claims, status, tc.status)
+		}
+	}
+}
+
+func TestOidcCallbackRejects(t *testing.T) {
+	idp := newMockIdp(t)
+	app := setupOidc(t, idp)
+
+	for name, tamper := range map[string]func(*url.URL){
+		"wrong state": func(u *url.URL) {
+			q := u.Query()
+			q.Set("state", "forged")
+			u.RawQuery = q.Encode()
+		},
+		"wrong code": func(u *url.URL) {
+			q := u.Query()
+			q.Set("code", "forged")
+			u.RawQuery = q.Encode()
+		},
+		"idp error": func(u *url.URL) {
+			q := u.Query()
+			q.Set("error", "access_denied")
+			u.RawQuery = q.Encode()
+		},
+	} {
+		res := codeFlow(t, app, tamper)
+		if res.StatusCode != fiber.StatusUnauthorized || cookie(res, sessionCookie) != nil {
+			t.Errorf("%s: got %d", name, res.StatusCode)
+		}
+	}
+}
+
+func TestOidcBearer(t *testing.T) {
+	idp := newMockIdp(t)
+	app := setupOidc(t, idp)
+
+	token := idp.sign(map[string]any{
+		"iss": idp.URL, "aud": testClientId, "sub": "subject-2",
+		"exp": time.Now().Add(time.Hour).Unix(), "email": "bob@example.com", "email_verified": true,
+	})
+	expired := idp.sign(map[string]any{
+		"iss": idp.URL, "aud": testClientId, "sub": "subject-2",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+	otherAud := idp.sign(map[string]any{
+		"iss": idp.URL, "aud": "other", "sub": "subject-2",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	for _, tc := range []struct {
+		token  string
+		status int
+	}{
+		{token, fiber.StatusOK},
+		{expired, fiber.StatusUnauthorized},
+		{otherAud, fiber.StatusUnauthorized},
+		{strings.TrimSuffix(token, token[len(token)-4:]) + "AAAA", fiber.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/whoami", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.status {
+			t.Errorf("got %d, expected %d", res.StatusCode, tc.status)
+		}
+	}
+}
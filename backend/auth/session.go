@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Key to sign cookies, persisted in the db so that sessions survive a restart
+var sessionKey []byte
+
+func mac(data string) string {
+	h := hmac.New(sha256.New, sessionKey)
+	h.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Serializes and signs v, to be stored client side (e.g. in a cookie)
+func seal(v any) (string, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	data := base64.RawURLEncoding.EncodeToString(bs)
+	return data + "." + mac(data), nil
+}
+
+// Checks the signature and deserializes into v. Returns false if
+// the value was tampered with or malformed.
+func unseal(sealed string, v any) bool {
+	data, sig, ok := strings.Cut(sealed, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(mac(data))) {
+		return false
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(bs, v) == nil
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	sessionKey = []byte("0123456789abcdef0123456789abcdef")
+
+	in := session{Name: "alice@example.com", Exp: 1234}
+	sealed, err := seal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out session
+	if !unseal(sealed, &out) || out != in {
+		t.Fatalf("roundtrip: got %v", out)
+	}
+
+	data, sig, _ := strings.Cut(sealed, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"n":"admin","e":1234}`))
+	for name, bad := range map[string]string{
+		"empty":        "",
+		"no signature": data,
+		"forged data":  forged + "." + sig,
+		"bad sig":      data + "." + sig[:len(sig)-2] + "AA",
+		"not base64":   "!!!." + mac("!!!"),
+		"not json":     "YWJj." + mac("YWJj"),
+	} {
+		var s session
+		if unseal(bad, &s) {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	// Another key, e.g. another instance
+	sessionKey = []byte("fedcba9876543210fedcba9876543210")
+	if unseal(sealed, &out) {
+		t.Errorf("accepted with another key")
+	}
+}
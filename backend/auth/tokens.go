@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"seif/db_ops"
+	"seif/params"
+	"strings"
+)
+
+const tokenPrefix = "seif_"
+
+// hash -> name, from the tokens file
+var fileTokens = map[string]string{}
+
+// Tokens are random, so a plain SHA-256 is enough to store them
+func HashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func NewToken() (string, error) {
+	bs := make([]byte, 24)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+// One token per line, as "name:sha256-in-hex". Empty lines and lines
+// starting with # are ignored.
+func loadTokensFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		txt := strings.TrimSpace(scanner.Text())
+		if txt == "" || strings.HasPrefix(txt, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(txt, ":")
+		hash = strings.ToLower(hash)
+		if bs, err := hex.DecodeString(hash); !ok || name == "" || err != nil || len(bs) != sha256.Size {
+			return fmt.Errorf("line %d is malformed", line)
+		}
+		fileTokens[hash] = name
+	}
+	return scanner.Err()
+}
+
+func checkToken(token string) (*Principal, error) {
+	if token == "" {
+		return nil, nil
+	}
+	hash := HashToken(token)
+
+	if name, ok := fileTokens[hash]; ok {
+		return &Principal{Method: "token", Name: name}, nil
+	}
+
+	if params.AuthDbTokens {
+		name, err := db_ops.GetTokenName(hash)
+		if err != nil {
+			return nil, err
+		}
+		if name != "" {
+			return &Principal{Method: "token", Name: name}, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"seif/db_ops"
+	"seif/params"
+	"strings"
+	"testing"
+)
+
+func TestHashToken(t *testing.T) {
+	if h := HashToken("seif_test"); h != "f773f30331d22c9488067c7d83bc1891c6dca56416b0b6993982b27284caad68" {
+		t.Errorf("unexpected hash %s", h)
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	a, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewToken()
+	if !strings.HasPrefix(a, tokenPrefix) || a == b {
+		t.Errorf("bad tokens %s, %s", a, b)
+	}
+}
+
+func TestTokensFile(t *testing.T) {
+	fileTokens = map[string]string{}
+	t.Cleanup(func() { fileTokens = map[string]string{} })
+
+	path := filepath.Join(t.TempDir(), "tokens")
+	content := "# comment\n\nci:" + strings.ToUpper(HashToken("seif_ci")) + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTokensFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := checkToken("seif_ci")
+	if err != nil || p == nil || *p != (Principal{Method: "token", Name: "ci"}) {
+		t.Errorf("valid token: got %v, %v", p, err)
+	}
+	for _, token := range []string{"", "seif_other", HashToken("seif_ci")} {
+		if p, err := checkToken(token); p != nil || err != nil {
+			t.Errorf("token %q: got %v, %v", token, p, err)
+		}
+	}
+
+	for _, bad := range []string{"ci\n", ":" + HashToken("x") + "\n", "ci:abcd\n", "ci:" + strings.Repeat("z", 64) + "\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadTokensFile(path); err == nil {
+			t.Errorf("%q: should be malformed", bad)
+		}
+	}
+}
+
+func TestDbTokens(t *testing.T) {
+	openTestDb(t)
+	params.AuthDbTokens = true
+	t.Cleanup(func() { params.AuthDbTokens = false })
+
+	if err := db_ops.PutToken("deploy", HashToken("seif_deploy")); err != nil {
+		t.Fatal(err)
+	}
+	p, err := checkToken("seif_deploy")
+	if err != nil || p == nil || *p != (Principal{Method: "token", Name: "deploy"}) {
+		t.Errorf("valid token: got %v, %v", p, err)
+	}
+	if p, err := checkToken("seif_nope"); p != nil || err != nil {
+		t.Errorf("unknown token: got %v, %v", p, err)
+	}
+
+	if _, err := db_ops.DelToken("deploy"); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := checkToken("seif_deploy"); p != nil {
+		t.Errorf("deleted token still valid")
+	}
+}
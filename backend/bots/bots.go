@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package bots
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"seif/audit"
+	"seif/db_ops"
+	"seif/logging"
+	"seif/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// How long a reveal nonce is valid, from when the page asks for it
+const NonceTtl = 2 * time.Minute
+
+const HeaderRevealNonce = "X-Reveal-Nonce"
+
+// Lowercase tokens of the user agents of link previewers, crawlers and mail
+// scanners; they're specific, as generic words like "bot" are also in the
+// user agents of browsers on some phones ("Cubot") and tools ("robot")
+var previewAgents = []string{
+	"googlebot", "bingbot", "applebot", "duckduckbot", "yandexbot", "baiduspider",
+	"slackbot", "slack-imgproxy", "discordbot", "telegrambot", "whatsapp",
+	"twitterbot", "linkedinbot", "facebookexternalhit", "facebot", "pinterestbot",
+	"redditbot", "skypeuripreview", "vkshare", "embedly", "iframely",
+	"microsoft office existence discovery", "outlook-ios", "google-safety",
+	"barracuda", "mimecast", "proofpoint", "symantec", "headlesschrome",
+}
+
+var nonceKey []byte
+
+// The nonces already used, with their expiry, so that each allows one reveal;
+// they're forgotten on restart, but they expire soon anyway
+var used = struct {
+	sync.Mutex
+	nonces map[string]int64
+}{nonces: map[string]int64{}}
+
+// Loads the key for the nonces. Aborts on errors, as it's called at startup.
+func Init() {
+	var err error
+	if nonceKey, err = db_ops.GetKey("reveal_nonce_key", 32); err != nil {
+		utils.Abort("in loading the reveal nonce key: %s", err)
+	}
+}
+
+// Whether a user agent looks like a link previewer or a scanner
+func IsPreview(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, agent := range previewAgents {
+		if strings.Contains(ua, agent) {
+			return true
+		}
+	}
+	return false
+}
+
+// Refuses, and logs, the requests from link previewers and scanners
+func Block(c *fiber.Ctx) error {
+	ua := c.Get(fiber.HeaderUserAgent)
+	if !IsPreview(ua) {
+		return c.Next()
+	}
+
+	logging.FromCtx(c).Warn("blocked a link preview", "user_agent", ua)
+	audit.Count(c, db_ops.AUDIT_BOT_BLOCKED)
+	return utils.SendError(c, utils.FHE018, "", nil)
+}
+
+func sign(id string, expiry int64, random string) string {
+	mac := hmac.New(sha256.New, nonceKey)
+	fmt.Fprintf(mac, "%s|%d|%s", id, expiry, random)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// A nonce that allows to reveal the secret with the given ID, once, for
+// NonceTtl. The random part tells apart those for the same secret.
+func NewNonce(id string) string {
+	expiry := time.Now().Add(NonceTtl).Unix()
+	random := rand.Text()
+	return strconv.FormatInt(expiry, 10) + "." + random + "." + sign(id, expiry, random)
+}
+
+// Whether the nonce is valid for the ID, not expired and not used before;
+// it's then used up, even if the reveal fails
+func CheckNonce(id, nonce string) bool {
+	parts := strings.Split(nonce, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	now := time.Now().Unix()
+	if err != nil || now > expiry || !hmac.Equal([]byte(parts[2]), []byte(sign(id, expiry, parts[1]))) {
+		return false
+	}
+
+	used.Lock()
+	defer used.Unlock()
+	for n, e := range used.nonces {
+		if now > e {
+			delete(used.nonces, n)
+		}
+	}
+	if _, ok := used.nonces[nonce]; ok {
+		return false
+	}
+	used.nonces[nonce] = expiry
+	return true
+}
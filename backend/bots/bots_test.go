@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package bots
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsPreview(t *testing.T) {
+	for ua, preview := range map[string]bool{
+		"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0":                          false,
+		"Mozilla/5.0 (Linux; Android 10; Cubot X30) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36": false,
+		"robotframework-requests/0.9": false,
+		"Mozilla/5.0 (Macintosh) AppleWebKit/605.1.15 Version/17.0 Safari/605.1.15 Preview": false,
+		"curl/8.5.0": false,
+		"":           false,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                  true,
+		"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)":                   true,
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)":                                true,
+		"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)":                         true,
+		"TelegramBot (like TwitterBot)":                                                             true,
+		"WhatsApp/2.23.20.0 A":                                                                      true,
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)":                 true,
+		"Microsoft Office Existence Discovery":                                                      true,
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 HeadlessChrome/120.0.0.0 Safari/537.36": true,
+	} {
+		if got := IsPreview(ua); got != preview {
+			t.Errorf("%q: got %v", ua, got)
+		}
+	}
+}
+
+func TestNonce(t *testing.T) {
+	nonceKey = []byte("0123456789abcdef0123456789abcdef")
+
+	nonce := NewNonce("secret-a")
+	parts := strings.Split(nonce, ".")
+	past := time.Now().Add(-time.Second).Unix()
+	expired := strconv.FormatInt(past, 10) + ".r." + sign("secret-a", past, "r")
+	// The expiry and the random part are covered by the MAC
+	extended := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + "." + parts[1] + "." + parts[2]
+	rerolled := parts[0] + ".other." + parts[2]
+	tampered := parts[0] + "." + parts[1] + "." + parts[2][:len(parts[2])-2] + "AA"
+
+	for i, tc := range []struct {
+		id, nonce string
+		ok        bool
+	}{
+		{"secret-b", nonce, false},
+		{"secret-a", tampered, false},
+		{"secret-a", expired, false},
+		{"secret-a", extended, false},
+		{"secret-a", rerolled, false},
+		{"secret-a", "", false},
+		{"secret-a", "garbage", false},
+		{"secret-a", "x." + parts[1] + "." + parts[2], false},
+		{"secret-a", parts[0] + "." + parts[2], false},
+		{"secret-a", nonce, true},
+		{"secret-a", nonce, false}, // once only
+	} {
+		if ok := CheckNonce(tc.id, tc.nonce); ok != tc.ok {
+			t.Errorf("%d: %s %q got %v", i, tc.id, tc.nonce, ok)
+		}
+	}
+
+	if !CheckNonce("secret-a", NewNonce("secret-a")) {
+		t.Error("a new nonce for the same secret: refused")
+	}
+
+	// Another key, as after a restore on another db
+	other := NewNonce("secret-a")
+	nonceKey = []byte("fedcba9876543210fedcba9876543210")
+	if CheckNonce("secret-a", other) {
+		t.Error("another key: accepted")
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"seif/params"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Certificates and the ACME account key are kept here, next to the db
+func acmeCacheDir() string {
+	return filepath.Join(filepath.Dir(params.DbPath), "certs")
+}
+
+// Issues and renews the certificates for -acme-domain, using HTTP-01 (if
+// -http-redirect-port is set) or TLS-ALPN-01 challenges
+func newAcmeManager() (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: params.AcmeDirectory}
+
+	// E.g. for a local Pebble or an internal CA
+	if params.AcmeCaRoot != "" {
+		pem, err := os.ReadFile(params.AcmeCaRoot)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in the ACME CA root")
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	whitelist := autocert.HostWhitelist(params.AcmeDomains...)
+	hostPolicy := func(ctx context.Context, host string) error {
+		// HTTP-01 requests carry the port when it's not 80, e.g. from Pebble
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return whitelist(ctx, host)
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(acmeCacheDir()),
+		HostPolicy: hostPolicy,
+		Client:     client,
+		Email:      params.AcmeEmail,
+	}, nil
+}
+
+// Issuance errors surface only in the handshake, so log them
+func logErrors(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := getCertificate(hello)
+		if err != nil {
+			slog.Warn("getting the ACME certificate failed", "server_name", hello.ServerName, "error", err)
+		}
+		return cert, err
+	}
+}
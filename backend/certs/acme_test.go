@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"seif/params"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+// Issues a certificate from a local Pebble, answering the TLS-ALPN-01
+// challenge on port 5001, where Pebble's test config validates it. Skipped
+// unless PEBBLE_DIRECTORY is set, see the README.
+func TestAcmePebble(t *testing.T) {
+	directory := os.Getenv("PEBBLE_DIRECTORY")
+	if directory == "" {
+		t.Skip("PEBBLE_DIRECTORY not set")
+	}
+	domain := os.Getenv("PEBBLE_DOMAIN")
+	if domain == "" {
+		domain = "localhost"
+	}
+
+	params.DbPath = filepath.Join(t.TempDir(), "seif.db")
+	params.AcmeDirectory = directory
+	params.AcmeCaRoot = os.Getenv("PEBBLE_CA_ROOT")
+	params.AcmeDomains = []string{domain}
+	t.Cleanup(func() {
+		params.AcmeDirectory, params.AcmeCaRoot, params.AcmeDomains = "", "", nil
+	})
+
+	m, err := newAcmeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := m.TLSConfig()
+	config.NextProtos = []string{"http/1.1", acme.ALPNProto}
+
+	ln, err := tls.Listen("tcp", net.JoinHostPort("", "5001"), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go http.Serve(ln, http.NotFoundHandler())
+
+	// As the first client connecting with this name would do
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: domain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname(domain); err != nil {
+		t.Error(err)
+	}
+	// As domain, or domain+rsa, depending on the client
+	if cached, _ := filepath.Glob(filepath.Join(acmeCacheDir(), domain+"*")); len(cached) == 0 {
+		t.Error("certificate not cached")
+	}
+
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.test"}); err == nil {
+		t.Error("issued a certificate for a domain not in -acme-domain")
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"seif/params"
+	"seif/utils"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/acme"
+)
+
+const pollPeriod = 10 * time.Second
+
+var config *tls.Config
+
+// Whether the server speaks TLS
+func Enabled() bool {
+	return config != nil
+}
+
+// The TLS config for the listener; nil if TLS is disabled
+func Config() *tls.Config {
+	return config
+}
+
+// Sets up TLS from the flags. Aborts on errors, as it's called at startup.
+func Init() {
+	hasFiles := params.TlsCert != "" || params.TlsKey != ""
+	hasAcme := len(params.AcmeDomains) > 0
+
+	// The combinations are validated with the flags
+	switch {
+	case !hasFiles && !hasAcme:
+		return
+	case hasAcme:
+		m, err := newAcmeManager()
+		if err != nil {
+			utils.Abort("in setting up ACME: %s", err)
+		}
+		config = m.TLSConfig()
+		config.MinVersion = tls.VersionTLS12
+		config.GetCertificate = logErrors(config.GetCertificate)
+		// fasthttp doesn't speak HTTP/2; keep the TLS-ALPN-01 protocol
+		config.NextProtos = []string{"http/1.1", acme.ALPNProto}
+		httpHandler = m.HTTPHandler(httpHandler)
+	default:
+		r := &reloader{certFile: params.TlsCert, keyFile: params.TlsKey}
+		if err := r.load(); err != nil {
+			utils.Abort("in loading the TLS certificate: %s", err)
+		}
+		go r.watch()
+
+		config = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: r.getCertificate,
+		}
+	}
+
+	if params.TlsClientCa != "" {
+		pem, err := os.ReadFile(params.TlsClientCa)
+		if err != nil {
+			utils.Abort("in loading the TLS client CA: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			utils.Abort("in loading the TLS client CA: no certificates found")
+		}
+		// Client certificates are only required for some routes, see
+		// RequireClientCert
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = pool
+	}
+}
+
+// Keeps the certificate in sync with its files, reloading it on SIGHUP
+// or when they change
+type reloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (r *reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *reloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+func (r *reloader) lastModified() (time.Time, error) {
+	var ret time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return ret, err
+		}
+		if fi.ModTime().After(ret) {
+			ret = fi.ModTime()
+		}
+	}
+	return ret, nil
+}
+
+// On errors, keeps serving the previous certificate
+func (r *reloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	tick := time.NewTicker(pollPeriod)
+
+	for {
+		select {
+		case <-hup:
+		case <-tick.C:
+			modTime, err := r.lastModified()
+			r.mu.RLock()
+			changed := err == nil && !modTime.Equal(r.modTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+		}
+
+		if err := r.load(); err != nil {
+			slog.Error("reloading the TLS certificate failed", "error", err)
+		} else {
+			slog.Info("reloaded the TLS certificate")
+		}
+	}
+}
+
+var errNoClientCert = errors.New("no valid client certificate")
+
+// Only lets through requests with a client certificate signed by the
+// configured CA; a no-op without -tls-client-ca
+func RequireClientCert(c *fiber.Ctx) error {
+	if config == nil || config.ClientCAs == nil {
+		return c.Next()
+	}
+	state := c.Context().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		err := errNoClientCert
+		return utils.SendError(c, utils.FHE016, "", &err)
+	}
+	return c.Next()
+}
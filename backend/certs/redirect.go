@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package certs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"seif/params"
+	"seif/utils"
+	"strconv"
+	"time"
+)
+
+// What's served on plain HTTP: redirects and, with ACME, HTTP-01 challenges
+var httpHandler http.Handler = http.HandlerFunc(redirect)
+
+var redirectServer *http.Server
+
+// Where HTTPS is served, as set by StartRedirect
+var httpsPort int
+
+// Serves plain HTTP on -http-redirect-port, redirecting to HTTPS on port
+// (0 if unknown, e.g. behind a unix socket: the default one), in the
+// background; see StopRedirect
+func StartRedirect(port int) {
+	if params.HttpRedirectPort == 0 {
+		return
+	}
+	httpsPort = port
+
+	addr := fmt.Sprintf(":%d", params.HttpRedirectPort)
+	redirectServer = &http.Server{Addr: addr, Handler: httpHandler}
+	slog.Info("redirecting HTTP to HTTPS", "port", params.HttpRedirectPort)
+	go func() {
+		if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			utils.Abort("in serving HTTP redirects: %s", err)
+		}
+	}()
+}
+
+// Stops serving HTTP, waiting up to timeout for the requests in progress
+func StopRedirect(timeout time.Duration) error {
+	if redirectServer == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return redirectServer.Shutdown(ctx)
+}
+
+func redirect(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if httpsPort != 0 && httpsPort != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package cli
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"seif/utils"
+	"strings"
+)
+
+const adminUsage = `Usage: seif admin [options] <command>
+
+Commands:
+  stats                  counts and sizes of the secrets
+  list                   metadata of the secrets (never the contents)
+  purge <id>             deletes a secret
+  purge-older <age>      deletes the secrets created more than age ago (e.g. 36h, 2d)
+  maint                  runs the maintenance (cleanup and vacuum) now
+  backup                 runs a backup now
+
+Options:
+`
+
+type adminClient struct {
+	base  string
+	token string
+	user  string
+}
+
+func (ac *adminClient) call(method, path string, query url.Values) []byte {
+	u := strings.TrimSuffix(ac.base, "/") + "/api/admin" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		utils.Abort("%s", err)
+	}
+	if ac.token != "" {
+		req.Header.Set("Authorization", "Bearer "+ac.token)
+	} else if user, pass, ok := strings.Cut(ac.user, ":"); ok {
+		req.SetBasicAuth(user, pass)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		utils.Abort("%s", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		utils.Abort("%s", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Code    string  `json:"code"`
+			Message string  `json:"message"`
+			Error   *string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) != nil || e.Message == "" {
+			utils.Abort("%s", res.Status)
+		}
+		msg := e.Message
+		if e.Error != nil {
+			msg += ": " + *e.Error
+		}
+		utils.Abort("%s (%s)", msg, res.Status)
+	}
+	return body
+}
+
+func printJson(body []byte) {
+	var out bytes.Buffer
+	if json.Indent(&out, body, "", "  ") != nil {
+		os.Stdout.Write(body)
+		return
+	}
+	fmt.Println(out.String())
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// seif admin ...
+func Admin(args []string) {
+	fs := flag.NewFlagSet("admin", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, adminUsage)
+		fs.PrintDefaults()
+	}
+	_url := fs.String("url", envOr("SEIF_URL", "http://localhost:34543"), "Base URL of the server (env SEIF_URL)")
+	_token := fs.String("token", "", "API token (env SEIF_TOKEN)")
+	_user := fs.String("user", "", "user:password for basic auth, if no token is given (env SEIF_USER)")
+	_limit := fs.Int("limit", 100, "For list, maximum number of secrets to list")
+	_offset := fs.Int("offset", 0, "For list, number of secrets to skip")
+	fs.Parse(args)
+
+	// Read after parsing, so that -h doesn't show the token from the environment
+	token := cmp.Or(*_token, os.Getenv("SEIF_TOKEN"))
+	user := cmp.Or(*_user, os.Getenv("SEIF_USER"))
+
+	ac := adminClient{base: *_url, token: token, user: user}
+
+	var purged struct {
+		Purged int64 `json:"purged"`
+	}
+	var task struct {
+		DurationMs int64 `json:"duration_ms"`
+	}
+
+	switch cmd, arg := fs.Arg(0), fs.Arg(1); {
+	case cmd == "stats":
+		printJson(ac.call(http.MethodGet, "/stats", nil))
+	case cmd == "list":
+		printJson(ac.call(http.MethodGet, "/secrets", url.Values{"limit": {fmt.Sprint(*_limit)}, "offset": {fmt.Sprint(*_offset)}}))
+	case cmd == "purge" && arg != "":
+		json.Unmarshal(ac.call(http.MethodDelete, "/secrets/"+url.PathEscape(arg), nil), &purged)
+		fmt.Printf("purged %d secret(s)\n", purged.Purged)
+	case cmd == "purge-older" && arg != "":
+		json.Unmarshal(ac.call(http.MethodDelete, "/secrets", url.Values{"older_than": {arg}}), &purged)
+		fmt.Printf("purged %d secret(s)\n", purged.Purged)
+	case cmd == "maint" || cmd == "backup":
+		json.Unmarshal(ac.call(http.MethodPost, "/"+cmd, nil), &task)
+		fmt.Printf("%s done in %d ms\n", cmd, task.DurationMs)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"seif/db_ops"
+	"seif/params"
+	"seif/utils"
+)
+
+const auditUsage = `Usage: seif audit [-db path] verify [anchor]
+
+Checks the hash chain of the audit log, from the last pruning if any, and
+prints the number of records and the hash of the last one. Save it elsewhere:
+passing it later as anchor checks that the log wasn't truncated or rewritten
+since, if it's still within the retention.
+`
+
+// seif audit ...
+func Audit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, auditUsage) }
+	_db := fs.String("db", "./seif.db", "The path of the sqlite database")
+	fs.Parse(args)
+
+	params.DbPath = *_db
+
+	if fs.Arg(0) != "verify" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db_ops.OpenExisting()
+	defer params.Db.Close()
+
+	count, head, err := db_ops.VerifyAudit(fs.Arg(1))
+	if err != nil {
+		utils.Abort("audit log is NOT valid after %d records: %s", count, err)
+	}
+	fmt.Printf("audit log is valid: %d records, head %s\n", count, head)
+}
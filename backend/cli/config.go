@@ -0,0 +1,62 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package cli
+
+import (
+	"fmt"
+	"os"
+	"seif/flags"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const configUsage = `Usage: seif config check [-config file] [flags]
+
+Validates the configuration that the server would run with, given the flags,
+the SEIF_* environment variables and the config file, and prints it in the
+config file format, with the source of each setting.
+`
+
+// seif config ...
+func Config(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprint(os.Stderr, configUsage)
+		os.Exit(2)
+	}
+
+	if err := flags.Load(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(1)
+	}
+
+	for _, s := range flags.Effective() {
+		fmt.Printf("%-20s = %-40s # %s\n", s.Name, tomlValue(s.Value), s.Source)
+	}
+}
+
+// Encodes a value as TOML, as it would be in the config file; durations
+// become strings, as "30s", that the flags parse back
+func tomlValue(value any) string {
+	bs, err := toml.Marshal(map[string]any{"v": value})
+	if err != nil {
+		return fmt.Sprintf("%q", fmt.Sprint(value))
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(string(bs), "v = "), "\n")
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"seif/client"
+	"seif/utils"
+	"time"
+)
+
+const openUsage = `Usage: seif open [options] <link>
+
+Reveals the secret of a link, printing it on stdout; the secret is then deleted.
+
+Options:
+`
+
+// seif open ...
+func Open(args []string) {
+	fs := flag.NewFlagSet("open", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, openUsage)
+		fs.PrintDefaults()
+	}
+	_key := fs.String("key", "", "The key, if the link doesn't carry it")
+	_peek := fs.Bool("peek", false, "Only check that the secret can be revealed, without revealing it")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	base, id, key, err := client.ParseLink(fs.Arg(0))
+	if err != nil {
+		utils.Abort("%s", err)
+	}
+	keyInLink := key != ""
+	if *_key != "" {
+		key, keyInLink = *_key, false
+	}
+	c := client.New(base)
+	ctx := context.Background()
+
+	status, err := c.Status(ctx, id)
+	if client.IsNotFound(err) {
+		fmt.Fprintln(os.Stderr, "not available: expired, already revealed or wrong link")
+		os.Exit(1)
+	}
+	if err != nil {
+		utils.Abort("%s", err)
+	}
+	if status.Fingerprint != "" {
+		fmt.Fprintf(os.Stderr, "verification code: %s\n", status.Fingerprint)
+	}
+
+	if *_peek {
+		if status.Scheduled {
+			fmt.Printf("available from %s\n", status.NotBefore.Local().Format(time.RFC1123))
+		} else {
+			fmt.Println("available")
+		}
+		return
+	}
+
+	if key == "" {
+		utils.Abort("the link has no key, pass it with -key")
+	}
+	// As the server would do, if the key came in the URL
+	if status.SeparateKey && keyInLink {
+		utils.Abort("the key of this secret must be given separately, not in the link: pass it with -key")
+	}
+	secret, err := c.Reveal(ctx, id, key)
+	if err != nil {
+		utils.Abort("%s", err)
+	}
+	os.Stdout.WriteString(secret.Text)
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package cli
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"seif/client"
+	"seif/qr"
+	"seif/utils"
+	"strings"
+	"time"
+)
+
+const sendUsage = `Usage: seif send [options] [file]
+
+Creates a secret with the contents of the file, or of stdin, and prints its link.
+With -qr, the QR code of the link is written to a file, or shown in the terminal.
+
+Options:
+`
+
+// seif send ...
+func Send(args []string) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, sendUsage)
+		fs.PrintDefaults()
+	}
+	_url := fs.String("url", envOr("SEIF_URL", "http://localhost:34543"), "Base URL of the server (env SEIF_URL)")
+	_token := fs.String("token", "", "API token, if the server requires authentication (env SEIF_TOKEN)")
+	_user := fs.String("user", "", "user:password for basic auth, if no token is given (env SEIF_USER)")
+	_expiry := fs.Int("expiry", 0, "Days before the secret expires (default: the server's default)")
+	_notBefore := fs.String("not-before", "", "The secret can't be revealed before this time, RFC3339 (e.g. 2024-05-01T09:00:00Z)")
+	_qr := fs.String("qr", "", "Also writes the link as a QR code to this file, .png or .svg, or to the terminal if -")
+	_qrNoKey := fs.Bool("qr-no-key", false, "The QR code has the link without the key, to give separately")
+	_separateKey := fs.Bool("separate-key", false, "The key is to be given separately: the link won't carry it, and it's printed on the next line")
+	_fingerprint := fs.Bool("fingerprint", false, "Prints a verification code of the key on stderr, that the recipient will see too")
+	fs.Parse(args)
+
+	// Not the defaults of the flags, or the usage would print them
+	token := cmp.Or(*_token, os.Getenv("SEIF_TOKEN"))
+	user := cmp.Or(*_user, os.Getenv("SEIF_USER"))
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	in := os.Stdin
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			utils.Abort("%s", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	text, err := io.ReadAll(in)
+	if err != nil {
+		utils.Abort("in reading the secret: %s", err)
+	}
+	if len(text) == 0 {
+		utils.Abort("the secret is empty")
+	}
+
+	c := client.New(*_url)
+	c.Token = token
+	c.User, c.Password, _ = strings.Cut(user, ":")
+	ctx := context.Background()
+
+	// What the server accepts is checked here, to fail before uploading
+	data, err := c.InitData(ctx)
+	if err != nil {
+		utils.Abort("%s", err)
+	}
+	req := client.PutRequest{Text: string(text), Expiry: *_expiry, SeparateKey: *_separateKey, Fingerprint: *_fingerprint}
+	if req.Expiry == 0 {
+		req.Expiry = data.DefaultDays
+	}
+	if req.Expiry < 1 || req.Expiry > data.MaxDays {
+		utils.Abort("-expiry must be between 1 and %d days", data.MaxDays)
+	}
+	if data.HasCapabilities() {
+		switch {
+		case len(text) > data.Limits.MaxBytes:
+			utils.Abort("the secret is %d bytes, the server accepts up to %d", len(text), data.Limits.MaxBytes)
+		case *_notBefore != "" && !data.Features.Scheduled:
+			utils.Abort("the server doesn't support -not-before")
+		case *_separateKey && !data.Features.SeparateKey:
+			utils.Abort("the server doesn't support -separate-key")
+		case *_fingerprint && !data.Features.Fingerprint:
+			utils.Abort("the server doesn't support -fingerprint")
+		}
+	}
+	if *_notBefore != "" {
+		nb, err := time.Parse(time.RFC3339, *_notBefore)
+		if err != nil {
+			utils.Abort("-not-before is malformed: %s", err)
+		}
+		req.NotBefore = &nb
+	}
+
+	created, err := c.Put(ctx, req)
+	if err != nil {
+		utils.Abort("%s", err)
+	}
+	fmt.Println(created.Link)
+	if *_separateKey {
+		fmt.Println(created.Key)
+	}
+	if created.Fingerprint != "" {
+		fmt.Fprintf(os.Stderr, "verification code: %s\n", created.Fingerprint)
+	}
+
+	if *_qr != "" {
+		link := created.Link
+		if *_qrNoKey && !*_separateKey {
+			u, err := url.Parse(link)
+			if err != nil {
+				utils.Abort("%s", err)
+			}
+			u.RawQuery = url.Values{"t": {created.Id}}.Encode()
+			link = u.String()
+			fmt.Fprintf(os.Stderr, "key: %s\n", created.Key)
+		}
+		writeQr(*_qr, link)
+	}
+}
+
+// The QR code is rendered here, not by the server
+func writeQr(file, link string) {
+	var img []byte
+	var err error
+	switch {
+	case file == "-":
+		var s string
+		if s, err = qr.Terminal(link); err == nil {
+			fmt.Fprint(os.Stderr, s)
+		}
+	case strings.HasSuffix(strings.ToLower(file), ".svg"):
+		img, err = qr.SVG(link, qr.DefaultSize)
+	case strings.HasSuffix(strings.ToLower(file), ".png"):
+		img, err = qr.PNG(link, qr.DefaultSize)
+	default:
+		utils.Abort("-qr must end in .png or .svg, or be -")
+	}
+	if err == nil && img != nil {
+		err = os.WriteFile(file, img, 0600)
+	}
+	if err != nil {
+		utils.Abort("in writing the QR code: %s", err)
+	}
+}
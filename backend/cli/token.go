@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"seif/auth"
+	"seif/db_ops"
+	"seif/params"
+	"seif/utils"
+)
+
+const tokenUsage = `Usage: seif token [-db path] <command>
+
+Commands:
+  new <name>     creates a token in the db, and prints it
+  list           lists the names of the tokens in the db
+  revoke <name>  deletes a token from the db
+  gen <name>     prints a token and its line for -auth-tokens-file, without using the db
+
+Tokens in the db are accepted only if the server runs with -auth-db-tokens.
+`
+
+// seif token ...
+func Token(args []string) {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, tokenUsage) }
+	_db := fs.String("db", "./seif.db", "The path of the sqlite database")
+	fs.Parse(args)
+
+	params.DbPath = *_db
+
+	cmd := fs.Arg(0)
+	name := fs.Arg(1)
+	if cmd == "" || ((cmd == "new" || cmd == "revoke" || cmd == "gen") && name == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	switch cmd {
+	case "gen":
+		token, err := auth.NewToken()
+		if err != nil {
+			utils.Abort("in generating token: %s", err)
+		}
+		fmt.Printf("token: %s\nline:  %s:%s\n", token, name, auth.HashToken(token))
+	case "new":
+		db_ops.OpenExisting()
+		defer params.Db.Close()
+
+		token, err := auth.NewToken()
+		if err != nil {
+			utils.Abort("in generating token: %s", err)
+		}
+		if err := db_ops.PutToken(name, auth.HashToken(token)); err != nil {
+			utils.Abort("in saving token: %s", err)
+		}
+		fmt.Println(token)
+	case "list":
+		db_ops.OpenExisting()
+		defer params.Db.Close()
+
+		tokens, err := db_ops.ListTokens()
+		if err != nil {
+			utils.Abort("in listing tokens: %s", err)
+		}
+		for _, t := range tokens {
+			fmt.Printf("%s\t%s\n", t.Name, t.Ts)
+		}
+	case "revoke":
+		db_ops.OpenExisting()
+		defer params.Db.Close()
+
+		found, err := db_ops.DelToken(name)
+		if err != nil {
+			utils.Abort("in revoking token: %s", err)
+		}
+		if !found {
+			utils.Abort("token '%s' not found", name)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A client of the seif API. Encryption happens on the server, that doesn't
+// keep the key: whoever has the link, that carries it, can reveal the secret.
+type Client struct {
+	BaseURL string // e.g. https://seif.example.com
+	// Credentials for creating secrets, if the server requires them: an API
+	// token, or else user and password for basic auth
+	Token    string
+	User     string
+	Password string
+	HTTP     *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTP: http.DefaultClient}
+}
+
+// An error returned by the server
+type Error struct {
+	Status     int
+	Id         string // e.g. FHE017
+	Code       string // e.g. not_found
+	Message    string
+	Details    map[string]string
+	RetryAfter time.Duration // when it's worth retrying, if set
+}
+
+func (e *Error) Error() string {
+	if cause, ok := e.Details["cause"]; ok {
+		return fmt.Sprintf("%s: %s (%d)", e.Message, cause, e.Status)
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+}
+
+// Whether the secret doesn't exist (anymore): revealed, expired or never created
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == "not_found"
+}
+
+type InitData struct {
+	Version        string   `json:"version"`
+	MaxDays        int      `json:"max_days"`
+	DefaultDays    int      `json:"default_days"`
+	AuthRequired   bool     `json:"auth_required"`
+	LoginUrl       string   `json:"login_url,omitempty"`
+	Limits         Limits   `json:"limits"`
+	Features       Features `json:"features"`
+	ExpiryPresets  []int    `json:"expiry_presets"`
+	SecretTypes    []string `json:"secret_types"`
+	CryptoVersions []string `json:"crypto_versions"`
+	ApiVersions    []string `json:"api_versions"`
+}
+
+// What the server accepts; servers that predate them leave these at zero
+type Limits struct {
+	MaxBytes         int `json:"max_bytes"`
+	MaxDays          int `json:"max_days"`
+	DefaultDays      int `json:"default_days"`
+	MaxDelayDays     int `json:"max_delay_days"`
+	MaxViews         int `json:"max_views"`
+	MaxFileBytes     int `json:"max_file_bytes"`
+	QuotaBytesPerDay int `json:"quota_bytes_per_day"`
+}
+
+// What the server supports
+type Features struct {
+	AuthRequired  bool `json:"auth_required"`
+	Passphrase    bool `json:"passphrase"`
+	Files         bool `json:"files"`
+	ZeroKnowledge bool `json:"zero_knowledge"`
+	Scheduled     bool `json:"scheduled"`
+	SeparateKey   bool `json:"separate_key"`
+	Fingerprint   bool `json:"fingerprint"`
+	TypedSecrets  bool `json:"typed_secrets"`
+	Qr            bool `json:"qr"`
+}
+
+// Whether the server publishes its capabilities, i.e. if Limits and Features
+// are meaningful
+func (d *InitData) HasCapabilities() bool {
+	return d.Limits.MaxBytes > 0
+}
+
+// A secret: for text secrets, Type is "text" and Fields is empty; for typed
+// ones, Text is a rendering of the fields.
+type Secret struct {
+	Text   string          `json:"secret"`
+	Type   string          `json:"type"`
+	Fields json.RawMessage `json:"fields,omitempty"`
+}
+
+type PutRequest struct {
+	Text   string          `json:"secret,omitempty"`
+	Type   string          `json:"type,omitempty"`
+	Fields json.RawMessage `json:"fields,omitempty"`
+	Expiry int             `json:"expiry"` // days
+	// If set, the secret can't be revealed before it
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	// The key is to be given through another channel: the link won't carry
+	// it, and the server will refuse it in URLs
+	SeparateKey bool `json:"separate_key,omitempty"`
+	// Whether to make a verification code of the key, for the recipient
+	Fingerprint bool `json:"fingerprint,omitempty"`
+}
+
+type Created struct {
+	Id          string `json:"id"`
+	Key         string `json:"key"`
+	Link        string `json:"link"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// A scheduled secret can't be revealed before NotBefore
+type Status struct {
+	Id          string     `json:"id"`
+	Scheduled   bool       `json:"scheduled"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	SeparateKey bool       `json:"separate_key"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any, auth bool) error {
+	var body io.Reader
+	if in != nil {
+		bs, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(bs)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "seif-client")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth && c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else if auth && c.User != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	bs, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode >= 300 {
+		e := &Error{Status: res.StatusCode, Message: res.Status}
+		var wrapped struct {
+			Error *Error `json:"error"`
+		}
+		if json.Unmarshal(bs, &wrapped) == nil && wrapped.Error != nil {
+			e = wrapped.Error
+			e.Status = res.StatusCode
+		}
+		if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(bs, out)
+}
+
+// The settings of the server
+func (c *Client) InitData(ctx context.Context) (*InitData, error) {
+	ret := new(InitData)
+	if err := c.do(ctx, http.MethodGet, "/api/getInitData", nil, ret, false); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Creates a secret
+func (c *Client) Put(ctx context.Context, req PutRequest) (*Created, error) {
+	ret := new(Created)
+	if err := c.do(ctx, http.MethodPost, "/api/v2/secrets", req, ret, true); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Checks that a secret can be revealed, without revealing it
+func (c *Client) Status(ctx context.Context, id string) (*Status, error) {
+	ret := new(Status)
+	if err := c.do(ctx, http.MethodGet, "/api/v2/secrets/"+url.PathEscape(id), nil, ret, false); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Reveals a secret, that the server then deletes
+func (c *Client) Reveal(ctx context.Context, id, key string) (*Secret, error) {
+	path := "/api/v2/secrets/" + url.PathEscape(id)
+	var nonce struct {
+		Nonce string `json:"nonce"`
+	}
+	if err := c.do(ctx, http.MethodPost, path+"/reveal-nonce", nil, &nonce, false); err != nil {
+		return nil, err
+	}
+
+	ret := new(Secret)
+	req := map[string]string{"key": key, "nonce": nonce.Nonce}
+	if err := c.do(ctx, http.MethodPost, path+"/reveal", req, ret, false); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Splits a link to a secret, as made by the UI or by Put, into the base URL
+// of the server, the id and the key
+func ParseLink(link string) (baseURL, id, key string, err error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", "", "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", "", "", fmt.Errorf("not an absolute link: %s", link)
+	}
+	q := u.Query()
+	if id = q.Get("t"); id == "" {
+		return "", "", "", errors.New("no secret id in the link")
+	}
+	path := strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/nojs/reveal")
+	return u.Scheme + "://" + u.Host + path, id, q.Get("s"), nil
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// A server with one secret, that answers as seif's API v2
+func newMockServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	revealed := false
+	writeJSON := func(w http.ResponseWriter, status int, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	notFound := func(w http.ResponseWriter) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": map[string]any{
+			"id": "FHE017", "code": "not_found", "message": "secret not found", "details": map[string]string{"resource": "secret"},
+		}})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v2/secrets", func(w http.ResponseWriter, r *http.Request) {
+		user, password, basic := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer seif_ci" && (!basic || user != "bob" || password != "pw") {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"error": map[string]any{"id": "FHE012", "code": "unauthorized", "message": "authentication required"}})
+			return
+		}
+		var req PutRequest
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if req.Expiry > 30 {
+			w.Header().Set("Retry-After", "42")
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": map[string]any{
+				"id": "FHE006", "code": "expiry_too_long", "message": "too many days", "details": map[string]string{"cause": "max 30"},
+			}})
+			return
+		}
+		writeJSON(w, http.StatusCreated, Created{Id: "ID", Key: "KEY", Link: "http://seif.test/?s=KEY&t=ID"})
+	})
+	mux.HandleFunc("GET /api/v2/secrets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "ID" || revealed {
+			notFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"id": "ID", "scheduled": true, "not_before": "2030-01-02T03:04:05Z", "separate_key": false})
+	})
+	mux.HandleFunc("POST /api/v2/secrets/{id}/reveal-nonce", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"nonce": "NONCE-" + r.PathValue("id"), "expires_in": 120})
+	})
+	mux.HandleFunc("POST /api/v2/secrets/{id}/reveal", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["nonce"] != "NONCE-"+r.PathValue("id") {
+			writeJSON(w, http.StatusForbidden, map[string]any{"error": map[string]any{"id": "FHE019", "code": "nonce_required", "message": "nonce required"}})
+			return
+		}
+		if r.PathValue("id") != "ID" || revealed {
+			notFound(w)
+			return
+		}
+		if req["key"] != "KEY" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": map[string]any{"id": "FHE021", "code": "wrong_key", "message": "wrong key"}})
+			return
+		}
+		revealed = true
+		writeJSON(w, http.StatusOK, map[string]any{"secret": "Username: bob\n", "type": "credentials", "fields": map[string]string{"username": "bob"}})
+	})
+	mux.HandleFunc("GET /broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>bad gateway</html>", http.StatusBadGateway)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPut(t *testing.T) {
+	srv := newMockServer(t)
+	ctx := context.Background()
+
+	c := New(srv.URL + "/")
+	var e *Error
+	if _, err := c.Put(ctx, PutRequest{Text: "hello", Expiry: 1}); !errors.As(err, &e) || e.Status != http.StatusUnauthorized || e.Code != "unauthorized" {
+		t.Errorf("no credentials: got %v", err)
+	}
+
+	for _, creds := range []func(*Client){
+		func(c *Client) { c.Token = "seif_ci" },
+		func(c *Client) { c.User, c.Password = "bob", "pw" },
+	} {
+		c := New(srv.URL)
+		creds(c)
+		created, err := c.Put(ctx, PutRequest{Text: "hello", Expiry: 1})
+		if err != nil || created.Id != "ID" || created.Key != "KEY" {
+			t.Errorf("got %+v, %v", created, err)
+		}
+	}
+
+	c.Token = "seif_ci"
+	_, err := c.Put(ctx, PutRequest{Text: "hello", Expiry: 31})
+	if !errors.As(err, &e) || e.Status != http.StatusBadRequest || e.Id != "FHE006" || e.RetryAfter != 42*time.Second || err.Error() != "too many days: max 30 (400)" {
+		t.Errorf("refused: got %#v", err)
+	}
+}
+
+func TestStatusAndReveal(t *testing.T) {
+	srv := newMockServer(t)
+	ctx := context.Background()
+	c := New(srv.URL)
+
+	status, err := c.Status(ctx, "ID")
+	if err != nil || !status.Scheduled || status.NotBefore == nil || !status.NotBefore.Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("status: got %+v, %v", status, err)
+	}
+	if _, err := c.Status(ctx, "OTHER"); !IsNotFound(err) {
+		t.Errorf("status of another secret: got %v", err)
+	}
+
+	var e *Error
+	if _, err := c.Reveal(ctx, "ID", "WRONG"); !errors.As(err, &e) || e.Code != "wrong_key" || IsNotFound(err) {
+		t.Errorf("wrong key: got %v", err)
+	}
+	secret, err := c.Reveal(ctx, "ID", "KEY")
+	if err != nil || secret.Type != "credentials" || secret.Text != "Username: bob\n" || string(secret.Fields) != `{"username":"bob"}` {
+		t.Errorf("reveal: got %+v, %v", secret, err)
+	}
+	if _, err := c.Reveal(ctx, "ID", "KEY"); !IsNotFound(err) {
+		t.Errorf("reveal again: got %v", err)
+	}
+	if _, err := c.Status(ctx, "ID"); !IsNotFound(err) {
+		t.Errorf("status after reveal: got %v", err)
+	}
+}
+
+func TestErrorWithoutBody(t *testing.T) {
+	srv := newMockServer(t)
+	err := New(srv.URL).do(context.Background(), http.MethodGet, "/broken", nil, nil, false)
+	var e *Error
+	if !errors.As(err, &e) || e.Status != http.StatusBadGateway || e.Message != "502 Bad Gateway" || IsNotFound(err) {
+		t.Errorf("got %#v", err)
+	}
+}
+
+func TestParseLink(t *testing.T) {
+	for _, tc := range []struct {
+		link, base, id, key string
+		ok                  bool
+	}{
+		{"https://seif.example/?t=ID&s=KEY", "https://seif.example", "ID", "KEY", true},
+		{"https://seif.example/?t=ID", "https://seif.example", "ID", "", true},
+		{"https://seif.example?s=KEY&t=ID", "https://seif.example", "ID", "KEY", true},
+		{"https://seif.example/nojs/reveal?t=ID&s=KEY", "https://seif.example", "ID", "KEY", true},
+		{"http://localhost:34543/sub/path/?t=ID", "http://localhost:34543/sub/path", "ID", "", true},
+		{"https://seif.example/?s=KEY", "", "", "", false},
+		{"seif.example/?t=ID&s=KEY", "", "", "", false},
+		{"/?t=ID&s=KEY", "", "", "", false},
+		{"https://seif.example/%zz?t=ID", "", "", "", false},
+		{"", "", "", "", false},
+	} {
+		base, id, key, err := ParseLink(tc.link)
+		if (err == nil) != tc.ok || base != tc.base || id != tc.id || key != tc.key {
+			t.Errorf("%q: got %q %q %q, %v", tc.link, base, id, key, err)
+		}
+	}
+}
@@ -22,7 +22,10 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
+	"fmt"
 )
 
 const IV_LEN = 48 >> 3
@@ -31,6 +34,10 @@ const KEY_LEN = 96 >> 3
 const IV_LEN_COMPLETE = 12 // FIXME must be a real constant
 const KEY_LEN_COMPLETE = aes.BlockSize
 
+// Identifies the scheme above, for clients: AES-128-GCM on the server, with
+// 96-bit random keys
+const SCHEME = "aes-gcm-v1"
+
 func genRandomBytes(length int) ([]byte, error) {
 	ret := make([]byte, length)
 
@@ -54,6 +61,14 @@ func Str2bs(str string) ([]byte, error) {
 	return base64.URLEncoding.DecodeString(str)
 }
 
+// A short code derived from the key, for sender and recipient to compare
+// by voice; it reveals too little to help guessing the key
+func Fingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	n := binary.BigEndian.Uint32(sum[:4]) % 1000000
+	return fmt.Sprintf("%03d %03d", n/1000, n%1000)
+}
+
 func Encode(message string) (id []byte, key []byte, crypto []byte, err error) {
 	key, err = genRandomBytes(KEY_LEN)
 	if err != nil {
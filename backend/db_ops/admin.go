@@ -0,0 +1,288 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package db_ops
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"seif/params"
+	"time"
+)
+
+const COUNTER_CREATED = "created"
+const COUNTER_REVEALED = "revealed"
+const COUNTER_EXPIRED = "expired"
+const COUNTER_PURGED = "purged"
+
+const SQL_INC_COUNTER = `
+	INSERT INTO COUNTERS (NAME, VALUE) VALUES ($1, $2)
+	ON CONFLICT (NAME) DO UPDATE SET VALUE = VALUE + excluded.VALUE`
+const SQL_GET_COUNTERS = "SELECT NAME, VALUE FROM COUNTERS"
+
+// When a secret expires: expiry counts from the moment it can be revealed
+const SQL_EXPIRES_AT = "DATETIME(COALESCE(NOT_BEFORE, TS), '+' || EXPIRY || ' days')"
+
+var SQL_STATS = fmt.Sprintf(`
+	SELECT
+		COALESCE(SUM(EXPIRES_AT >= DATETIME('now')), 0),
+		COALESCE(SUM(EXPIRES_AT < DATETIME('now')), 0),
+		COALESCE(SUM(NOT_BEFORE > DATETIME('now')), 0),
+		MIN(EXPIRES_AT)
+	FROM (SELECT NOT_BEFORE, %s AS EXPIRES_AT FROM SECRETS)`, SQL_EXPIRES_AT)
+
+const SQL_SIZES = "SELECT LENGTH(SECRET) FROM SECRETS"
+
+var SQL_COUNT_ACTIVE = fmt.Sprintf("SELECT COUNT(*) FROM SECRETS WHERE %s >= DATETIME('now')", SQL_EXPIRES_AT)
+
+var SQL_LIST = fmt.Sprintf(`
+	SELECT ID, LENGTH(SECRET), FORMAT, TS, NOT_BEFORE, %s
+	FROM SECRETS
+	ORDER BY TS, ID
+	LIMIT $1 OFFSET $2`, SQL_EXPIRES_AT)
+
+const SQL_FIND_ID = "SELECT ID FROM SECRETS WHERE ID = $1"
+const SQL_FIND_OLDER = "SELECT ID FROM SECRETS WHERE TS < DATETIME('now', '-' || $1 || ' seconds')"
+const SQL_PURGE = "DELETE FROM SECRETS WHERE ID = $1"
+
+// Upper bounds of the size distribution's buckets, in bytes
+var sizeBuckets = []int{64, 256, 1024, 4096, 16384, 65536}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Adds n to a counter; ex can be the db or a transaction, as the caller
+// must already hold params.Lock
+func IncCounter(ex execer, name string, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	_, err := ex.Exec(SQL_INC_COUNTER, name, n)
+	return err
+}
+
+type SizeBucket struct {
+	UpTo  int `json:"up_to"` // 0 is infinity
+	Count int `json:"count"`
+}
+
+type Stats struct {
+	Active         int              `json:"active"`
+	Scheduled      int              `json:"scheduled"`       // active, but time-locked
+	ExpiredPending int              `json:"expired_pending"` // to be deleted by the next maintenance
+	OldestExpiry   *string          `json:"oldest_expiry"`
+	Totals         map[string]int64 `json:"totals"` // since the db was created
+	Sizes          []SizeBucket     `json:"sizes"`
+	DbBytes        int64            `json:"db_bytes"`
+}
+
+func GetStats() (*Stats, error) {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	ret := Stats{Totals: map[string]int64{}}
+	for _, c := range []string{COUNTER_CREATED, COUNTER_REVEALED, COUNTER_EXPIRED, COUNTER_PURGED} {
+		ret.Totals[c] = 0
+	}
+
+	var oldestExpiry sql.NullString
+	if err := params.Db.QueryRow(SQL_STATS).Scan(&ret.Active, &ret.ExpiredPending, &ret.Scheduled, &oldestExpiry); err != nil {
+		return nil, err
+	}
+	if oldestExpiry.Valid {
+		ret.OldestExpiry = toRfc3339(oldestExpiry.String)
+	}
+
+	rows, err := params.Db.Query(SQL_GET_COUNTERS)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var value int64
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		ret.Totals[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, upTo := range sizeBuckets {
+		ret.Sizes = append(ret.Sizes, SizeBucket{UpTo: upTo})
+	}
+	ret.Sizes = append(ret.Sizes, SizeBucket{})
+	sizes, err := params.Db.Query(SQL_SIZES)
+	if err != nil {
+		return nil, err
+	}
+	defer sizes.Close()
+	for sizes.Next() {
+		var size int
+		if err := sizes.Scan(&size); err != nil {
+			return nil, err
+		}
+		i := 0
+		for i < len(sizeBuckets) && size > sizeBuckets[i] {
+			i++
+		}
+		ret.Sizes[i].Count++
+	}
+	if err := sizes.Err(); err != nil {
+		return nil, err
+	}
+
+	if fi, err := os.Stat(params.DbPath); err == nil {
+		ret.DbBytes = fi.Size()
+	}
+
+	return &ret, nil
+}
+
+// Number of secrets that can still be revealed
+func CountActive() (float64, error) {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	var n int64
+	err := params.Db.QueryRow(SQL_COUNT_ACTIVE).Scan(&n)
+	return float64(n), err
+}
+
+func DbSize() (float64, error) {
+	fi, err := os.Stat(params.DbPath)
+	if err != nil {
+		return 0, err
+	}
+	return float64(fi.Size()), nil
+}
+
+// Metadata of a secret. Never the content, not even encrypted.
+type SecretMeta struct {
+	Id        string  `json:"id"`
+	Bytes     int     `json:"bytes"`
+	Typed     bool    `json:"typed"`
+	Created   *string `json:"created"`
+	NotBefore *string `json:"not_before,omitempty"`
+	Expires   *string `json:"expires"`
+}
+
+func ListSecrets(limit, offset int) ([]SecretMeta, error) {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	rows, err := params.Db.Query(SQL_LIST, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []SecretMeta{}
+	for rows.Next() {
+		var m SecretMeta
+		var format int
+		var created, notBefore, expires sql.NullString
+		if err := rows.Scan(&m.Id, &m.Bytes, &format, &created, &notBefore, &expires); err != nil {
+			return nil, err
+		}
+		m.Typed = format != 0
+		if created.Valid {
+			m.Created = toRfc3339(created.String)
+		}
+		if notBefore.Valid {
+			m.NotBefore = toRfc3339(notBefore.String)
+		}
+		if expires.Valid {
+			m.Expires = toRfc3339(expires.String)
+		}
+		ret = append(ret, m)
+	}
+	return ret, rows.Err()
+}
+
+// Deletes the secrets, auditing each with the given event and updating
+// the counter; the caller must hold params.Lock
+func deleteSecrets(tx *sql.Tx, ids []string, event AuditEvent, counter string) error {
+	for _, id := range ids {
+		if _, err := tx.Exec(SQL_PURGE, id); err != nil {
+			return err
+		}
+		event.Id = id
+		if err := AppendAudit(tx, event); err != nil {
+			return err
+		}
+	}
+	return IncCounter(tx, counter, int64(len(ids)))
+}
+
+// Purges the secret with the given id, or those created more than age ago
+// if id is "". by is the audit event, with who's purging. Returns how many
+// were purged.
+func PurgeSecrets(id string, age time.Duration, by AuditEvent) (int, error) {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	tx, err := params.Db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var rows *sql.Rows
+	if id != "" {
+		rows, err = tx.Query(SQL_FIND_ID, id)
+	} else {
+		rows, err = tx.Query(SQL_FIND_OLDER, int64(age.Seconds()))
+	}
+	if err != nil {
+		return 0, err
+	}
+	var found []string
+	for rows.Next() {
+		var f string
+		if err := rows.Scan(&f); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		found = append(found, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	by.Event = AUDIT_PURGED
+	if err := deleteSecrets(tx, found, by, COUNTER_PURGED); err != nil {
+		return 0, err
+	}
+	return len(found), tx.Commit()
+}
+
+func toRfc3339(dbTime string) *string {
+	t, err := time.Parse(TIME_FORMAT, dbTime)
+	if err != nil {
+		return &dbTime
+	}
+	ret := t.Format(time.RFC3339)
+	return &ret
+}
@@ -0,0 +1,290 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package db_ops
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"seif/params"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const AUDIT_CREATED = "created"
+const AUDIT_REVEALED = "revealed"
+const AUDIT_REVEAL_FAILED = "reveal_failed"
+const AUDIT_REVEAL_LOCKED = "reveal_locked"  // time-locked
+const AUDIT_KEY_IN_URL = "reveal_key_in_url" // key to be given separately
+const AUDIT_STATUS = "status"
+const AUDIT_EXPIRED = "expired"
+const AUDIT_PURGED = "purged"
+const AUDIT_AUTH_FAILED = "auth_failed"
+const AUDIT_RATE_LIMITED = "rate_limited"
+const AUDIT_BOT_BLOCKED = "bot_blocked"
+
+const SQL_AUDIT_LAST = "SELECT SEQ, HASH FROM AUDIT ORDER BY SEQ DESC LIMIT 1"
+const SQL_AUDIT_PUT = `
+	INSERT INTO AUDIT (SEQ, TS, EVENT, ID_HASH, IP, PRINCIPAL, COUNT, PREV, HASH)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+const SQL_AUDIT_ALL = "SELECT SEQ, TS, EVENT, ID_HASH, IP, PRINCIPAL, COUNT, PREV, HASH FROM AUDIT ORDER BY SEQ"
+
+// The last record is never pruned, as the next one chains to it
+const SQL_AUDIT_PRUNE_TO = `
+	SELECT SEQ, HASH FROM AUDIT
+	WHERE TS < DATETIME('now', '-' || $1 || ' days') AND SEQ < (SELECT MAX(SEQ) FROM AUDIT)
+	ORDER BY SEQ DESC LIMIT 1`
+const SQL_AUDIT_CHECKPOINT = "INSERT INTO AUDIT_CHECKPOINTS (SEQ, HASH, TS) VALUES ($1, $2, CURRENT_TIMESTAMP)"
+const SQL_AUDIT_PRUNE = "DELETE FROM AUDIT WHERE SEQ <= $1"
+const SQL_AUDIT_LAST_CHECKPOINT = "SELECT SEQ, HASH FROM AUDIT_CHECKPOINTS ORDER BY SEQ DESC LIMIT 1"
+
+// Distinct clients counted per event between two maintenances; the others
+// are counted together, with IP "*"
+const auditMaxCounted = 20
+
+var auditGenesis = strings.Repeat("0", sha256.Size*2)
+
+// Key for hashing the IDs of the secrets in the log, so that it can't be used
+// to find live secrets
+var auditKey []byte
+
+func InitAudit() error {
+	var err error
+	auditKey, err = GetKey("audit_key", 32)
+	return err
+}
+
+// What happened, to which secret (if any), and who did it. Never the content.
+type AuditEvent struct {
+	Event     string
+	Id        string
+	Ip        string
+	Principal string
+}
+
+type AuditRecord struct {
+	Seq       int64  `json:"seq"`
+	Ts        string `json:"ts"`
+	Event     string `json:"event"`
+	IdHash    string `json:"id_hash"`
+	Ip        string `json:"ip"`
+	Principal string `json:"principal"`
+	Count     int64  `json:"count"` // more than 1 for counted events, see CountAudit
+	Prev      string `json:"prev"`
+	Hash      string `json:"hash"`
+}
+
+type countKey struct {
+	Event     string
+	Ip        string
+	Principal string
+}
+
+// Events counted since the last maintenance
+var counted = struct {
+	sync.Mutex
+	counts map[countKey]int64
+}{counts: map[countKey]int64{}}
+
+func hashId(id string) string {
+	if id == "" {
+		return ""
+	}
+	h := hmac.New(sha256.New, auditKey)
+	h.Write([]byte(id))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Each record's hash covers all its fields, including the previous hash.
+// Single events hash as they did before they could be counted, so that the
+// older chains stay valid.
+func (r *AuditRecord) computeHash() string {
+	fields := []any{r.Seq, r.Ts, r.Event, r.IdHash, r.Ip, r.Principal, r.Prev}
+	if r.Count != 1 {
+		fields = append(fields, r.Count)
+	}
+	bs, _ := json.Marshal(fields)
+	h := sha256.Sum256(bs)
+	return hex.EncodeToString(h[:])
+}
+
+type querier interface {
+	execer
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Appends an event to the audit log; q can be the db or a transaction, as the
+// caller must already hold params.Lock
+func AppendAudit(q querier, e AuditEvent) error {
+	return appendRecord(q, AuditRecord{Event: e.Event, IdHash: hashId(e.Id), Ip: e.Ip, Principal: e.Principal, Count: 1})
+}
+
+func appendRecord(q querier, r AuditRecord) error {
+	r.Ts = time.Now().UTC().Format(TIME_FORMAT)
+	err := q.QueryRow(SQL_AUDIT_LAST).Scan(&r.Seq, &r.Prev)
+	if errors.Is(err, sql.ErrNoRows) {
+		r.Prev = auditGenesis
+	} else if err != nil {
+		return err
+	}
+	r.Seq++
+	r.Hash = r.computeHash()
+
+	_, err = q.Exec(SQL_AUDIT_PUT, r.Seq, r.Ts, r.Event, r.IdHash, r.Ip, r.Principal, r.Count, r.Prev, r.Hash)
+	return err
+}
+
+// Counts an event that anyone can cause at will, e.g. a rate-limited
+// request, instead of appending it: the counts are written at the next
+// maintenance, as one record per event and client. It doesn't touch the db,
+// so a flood doesn't grow it, nor hold params.Lock.
+func CountAudit(e AuditEvent) {
+	k := countKey{Event: e.Event, Ip: e.Ip, Principal: e.Principal}
+
+	counted.Lock()
+	defer counted.Unlock()
+
+	if _, ok := counted.counts[k]; !ok && len(counted.counts) >= auditMaxCounted {
+		k = countKey{Event: e.Event, Ip: "*"}
+	}
+	counted.counts[k]++
+}
+
+// Appends the records for the counted events; the caller holds params.Lock
+func flushAudit() error {
+	counted.Lock()
+	counts := counted.counts
+	counted.counts = map[countKey]int64{}
+	counted.Unlock()
+
+	if len(counts) == 0 {
+		return nil
+	}
+
+	keys := slices.SortedFunc(maps.Keys(counts), func(a, b countKey) int {
+		return cmp.Or(cmp.Compare(a.Event, b.Event), cmp.Compare(a.Ip, b.Ip), cmp.Compare(a.Principal, b.Principal))
+	})
+
+	tx, err := params.Db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, k := range keys {
+		if err := appendRecord(tx, AuditRecord{Event: k.Event, Ip: k.Ip, Principal: k.Principal, Count: counts[k]}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Deletes the records older than days, saving the hash of the last one
+// deleted as checkpoint: the rest of the chain is verified from there. The
+// caller holds params.Lock.
+func pruneAudit(days int) error {
+	tx, err := params.Db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var seq int64
+	var hash string
+	err = tx.QueryRow(SQL_AUDIT_PRUNE_TO, days).Scan(&seq, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(SQL_AUDIT_CHECKPOINT, seq, hash); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(SQL_AUDIT_PRUNE, seq); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Checks the whole chain, from the last checkpoint if it was pruned.
+// Returns the number of records and the hash of the last one, that can be
+// saved elsewhere and later passed as anchor: if the chain doesn't contain
+// it anymore, it was truncated or rewritten (or pruned, if older than the
+// retention).
+func VerifyAudit(anchor string) (count int64, head string, err error) {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	var from int64
+	head = auditGenesis
+	if err := params.Db.QueryRow(SQL_AUDIT_LAST_CHECKPOINT).Scan(&from, &head); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, "", err
+	}
+	if head == anchor {
+		anchor = ""
+	}
+
+	rows, err := params.Db.Query(SQL_AUDIT_ALL)
+	if err != nil {
+		return 0, "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r AuditRecord
+		if err := rows.Scan(&r.Seq, &r.Ts, &r.Event, &r.IdHash, &r.Ip, &r.Principal, &r.Count, &r.Prev, &r.Hash); err != nil {
+			return count, head, err
+		}
+		if r.Seq != from+count+1 {
+			return count, head, fmt.Errorf("record %d is missing", from+count+1)
+		}
+		if r.Prev != head {
+			return count, head, fmt.Errorf("record %d doesn't follow the previous one", r.Seq)
+		}
+		if r.computeHash() != r.Hash {
+			return count, head, fmt.Errorf("record %d was modified", r.Seq)
+		}
+		count++
+		head = r.Hash
+		if head == anchor {
+			anchor = ""
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return count, head, err
+	}
+	if anchor != "" && from > 0 {
+		return count, head, fmt.Errorf("anchor not found, the log was truncated or rewritten, or the anchor was pruned (the log starts after record %d)", from)
+	}
+	if anchor != "" {
+		return count, head, errors.New("anchor not found, the log was truncated or rewritten")
+	}
+	return count, head, nil
+}
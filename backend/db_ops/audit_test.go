@@ -0,0 +1,179 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package db_ops
+
+import (
+	"fmt"
+	"seif/params"
+	"strings"
+	"testing"
+	"time"
+)
+
+func appendTestAudit(t *testing.T, events ...string) {
+	t.Helper()
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+	for _, e := range events {
+		if err := AppendAudit(params.Db, AuditEvent{Event: e, Id: "id", Ip: "1.2.3.4", Principal: "token:ci"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func verifyTestAudit(t *testing.T, anchor string, count int64, errPart string) string {
+	t.Helper()
+	n, head, err := VerifyAudit(anchor)
+	if errPart == "" && err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if errPart != "" && (err == nil || !strings.Contains(err.Error(), errPart)) {
+		t.Fatalf("got %v, expected an error with %q", err, errPart)
+	}
+	if err == nil && n != count {
+		t.Fatalf("got %d records, expected %d", n, count)
+	}
+	return head
+}
+
+func exec(t *testing.T, query string) error {
+	t.Helper()
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+	_, err := params.Db.Exec(query)
+	return err
+}
+
+func TestAuditChain(t *testing.T) {
+	openTestDb(t)
+	if err := InitAudit(); err != nil {
+		t.Fatal(err)
+	}
+
+	appendTestAudit(t, AUDIT_CREATED, AUDIT_STATUS)
+	second := verifyTestAudit(t, "", 2, "")
+	appendTestAudit(t, AUDIT_REVEALED)
+
+	for range 3 {
+		CountAudit(AuditEvent{Event: AUDIT_RATE_LIMITED, Ip: "5.6.7.8"})
+	}
+	CountAudit(AuditEvent{Event: AUDIT_BOT_BLOCKED, Ip: "5.6.7.8"})
+	params.Lock.Lock()
+	err := flushAudit()
+	params.Lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head := verifyTestAudit(t, "", 5, "")
+	verifyTestAudit(t, head, 5, "")
+	verifyTestAudit(t, second, 5, "")
+	verifyTestAudit(t, "not a hash", 0, "anchor not found")
+
+	var count int64
+	if err := params.Db.QueryRow("SELECT COUNT FROM AUDIT WHERE EVENT = $1", AUDIT_RATE_LIMITED).Scan(&count); err != nil || count != 3 {
+		t.Errorf("rate limited: counted %d, %v", count, err)
+	}
+
+	if err := exec(t, "UPDATE AUDIT SET IP = '6.6.6.6'"); err == nil {
+		t.Error("update: allowed")
+	}
+	if err := exec(t, "DELETE FROM AUDIT WHERE SEQ = 1"); err == nil {
+		t.Error("delete: allowed")
+	}
+
+	// Prunes all but the last one, that the next record chains to
+	time.Sleep(1100 * time.Millisecond)
+	params.Lock.Lock()
+	err = pruneAudit(0)
+	params.Lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTestAudit(t, "", 1, "")
+	appendTestAudit(t, AUDIT_EXPIRED)
+	head = verifyTestAudit(t, "", 2, "")
+	verifyTestAudit(t, second, 0, "the anchor was pruned")
+
+	// Pruned records are only those before the checkpoint
+	if err := exec(t, "DELETE FROM AUDIT WHERE SEQ = 6"); err == nil {
+		t.Error("delete after checkpoint: allowed")
+	}
+	if err := exec(t, "DELETE FROM AUDIT_CHECKPOINTS"); err == nil {
+		t.Error("delete checkpoint: allowed")
+	}
+
+	// Someone with access to the file can drop the triggers, but not make
+	// up the hashes
+	if err := exec(t, "DROP TRIGGER AUDIT_NO_UPDATE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec(t, "UPDATE AUDIT SET IP = '6.6.6.6' WHERE SEQ = 6"); err != nil {
+		t.Fatal(err)
+	}
+	verifyTestAudit(t, head, 0, "record 6 was modified")
+}
+
+func TestAuditTruncated(t *testing.T) {
+	openTestDb(t)
+	if err := InitAudit(); err != nil {
+		t.Fatal(err)
+	}
+
+	appendTestAudit(t, AUDIT_CREATED, AUDIT_STATUS, AUDIT_REVEALED, AUDIT_EXPIRED)
+	head := verifyTestAudit(t, "", 4, "")
+
+	if err := exec(t, "DROP TRIGGER AUDIT_NO_DELETE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec(t, "DELETE FROM AUDIT WHERE SEQ = 4"); err != nil {
+		t.Fatal(err)
+	}
+	verifyTestAudit(t, head, 0, "anchor not found")
+	if err := exec(t, "DELETE FROM AUDIT WHERE SEQ = 2"); err != nil {
+		t.Fatal(err)
+	}
+	verifyTestAudit(t, "", 0, "record 2 is missing")
+}
+
+func TestCountAuditBounded(t *testing.T) {
+	openTestDb(t)
+
+	for i := range auditMaxCounted + 5 {
+		CountAudit(AuditEvent{Event: AUDIT_AUTH_FAILED, Ip: fmt.Sprintf("10.0.0.%d", i)})
+	}
+	params.Lock.Lock()
+	err := flushAudit()
+	params.Lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records, overflow int64
+	if err := params.Db.QueryRow("SELECT COUNT(*) FROM AUDIT").Scan(&records); err != nil {
+		t.Fatal(err)
+	}
+	if err := params.Db.QueryRow("SELECT COUNT FROM AUDIT WHERE IP = '*'").Scan(&overflow); err != nil {
+		t.Fatal(err)
+	}
+	if records != auditMaxCounted+1 || overflow != 5 {
+		t.Errorf("got %d records, %d in the overflow", records, overflow)
+	}
+	verifyTestAudit(t, "", auditMaxCounted+1, "")
+}
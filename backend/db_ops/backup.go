@@ -21,11 +21,14 @@ package db_ops
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"seif/metrics"
 	"seif/params"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -36,9 +39,15 @@ var bkpTimeGlob = strings.Repeat("?", len(bkpTimeFormat))
 const bkpFile = "seif_%s.db"
 const numFiles = 8
 
-func Backup() {
-	var bkpDir = filepath.Join(filepath.Dir(params.DbPath), "backups")
-	var err error
+// Backups started by BackupAsync and not yet done. Once backupsClosed is
+// set, under backupsMu, no more are started: WaitBackups can't race with Add.
+var pendingBackups sync.WaitGroup
+var backupsMu sync.Mutex
+var backupsClosed bool
+
+// Saves a copy of the db in the backups dir, keeping the last numFiles
+func Backup() (err error) {
+	var bkpDir = BackupDir()
 
 	if _, err = os.Stat(bkpDir); errors.Is(err, os.ErrNotExist) {
 		if err = os.Mkdir(bkpDir, 0755); err != nil {
@@ -50,23 +59,57 @@ func Backup() {
 	params.Lock.Lock()
 	defer params.Lock.Unlock()
 
+	start := time.Now()
+	defer func() {
+		metrics.BackupDuration.Observe(time.Since(start).Seconds())
+		recordBackup(err)
+		if err != nil {
+			metrics.BackupFailures.Inc()
+		}
+	}()
+
 	now := time.Now().Format(bkpTimeFormat)
 	fname := fmt.Sprintf(filepath.Join(bkpDir, bkpFile), now)
 	_, err = params.Db.Exec("VACUUM INTO ?", fname)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "backup: %s\n", err.Error())
-		return
+		slog.Error("backup failed", "error", err)
+		return err
 	}
 
 	// delete the backup files, except for the last n
 	list, err := filepath.Glob(fmt.Sprintf(filepath.Join(bkpDir, bkpFile), bkpTimeGlob))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "sched. task (pruning bkp files): %s\n", err.Error())
-		return
+		slog.Error("pruning backup files failed", "error", err)
+		return err
 	}
 
 	sort.Strings(list)
 	for i := 0; i < len(list)-numFiles; i++ {
 		os.Remove(list[i])
 	}
+	return nil
+}
+
+// Runs Backup in the background, unless shutting down; see WaitBackups
+func BackupAsync() {
+	backupsMu.Lock()
+	defer backupsMu.Unlock()
+	if backupsClosed {
+		return
+	}
+
+	pendingBackups.Add(1)
+	go func() {
+		defer pendingBackups.Done()
+		Backup()
+	}()
+}
+
+// Stops starting new backups, and waits for the ones in progress
+func WaitBackups() {
+	backupsMu.Lock()
+	backupsClosed = true
+	backupsMu.Unlock()
+
+	pendingBackups.Wait()
 }
@@ -24,8 +24,6 @@ import (
 	"seif/utils"
 )
 
-const DB_VERSION = 1
-
 const SQL_CREATE = `
  	CREATE TABLE SECRETS (
 		ID TEXT PRIMARY KEY NOT NULL,
@@ -34,9 +32,18 @@ const SQL_CREATE = `
 		TS TEXT
 	)`
 
-var SQL_CREATE_2 = fmt.Sprintf("CREATE VIEW VERSION AS SELECT %d AS VERSION", DB_VERSION)
+const SQL_CREATE_VERSION = "CREATE VIEW VERSION AS SELECT %d AS VERSION"
+
+// Format of the timestamps in the db, as written by CURRENT_TIMESTAMP (UTC)
+const TIME_FORMAT = "2006-01-02 15:04:05"
 
+// Creates the first version of the schema, then upgrades it to the current one
 func InitDb() {
+	createDb()
+	UpgradeDb(1)
+}
+
+func createDb() {
 	// Execute non-concurrently
 	params.Lock.Lock()
 	defer params.Lock.Unlock()
@@ -45,7 +52,7 @@ func InitDb() {
 		utils.Abort("in creating db: %s", err)
 	}
 
-	if _, err := params.Db.Exec(SQL_CREATE_2); err != nil {
+	if _, err := params.Db.Exec(fmt.Sprintf(SQL_CREATE_VERSION, 1)); err != nil {
 		utils.Abort("in creating db: %s", err)
 	}
 }
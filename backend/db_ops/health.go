@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package db_ops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"seif/params"
+	"sync"
+	"time"
+)
+
+// Outcome of the last run of a scheduled task
+type TaskRun struct {
+	At  time.Time
+	Err error
+}
+
+var lastRuns = struct {
+	sync.Mutex
+	maint  *TaskRun
+	backup *TaskRun
+}{}
+
+func recordMaint(err error) {
+	lastRuns.Lock()
+	defer lastRuns.Unlock()
+	lastRuns.maint = &TaskRun{At: time.Now(), Err: err}
+}
+
+func recordBackup(err error) {
+	lastRuns.Lock()
+	defer lastRuns.Unlock()
+	lastRuns.backup = &TaskRun{At: time.Now(), Err: err}
+}
+
+// Last runs of maintenance and backup; nil if they didn't run yet
+func LastRuns() (maint, backup *TaskRun) {
+	lastRuns.Lock()
+	defer lastRuns.Unlock()
+	return lastRuns.maint, lastRuns.backup
+}
+
+func BackupDir() string {
+	return filepath.Join(filepath.Dir(params.DbPath), "backups")
+}
+
+// Checks that the db answers and has the expected schema version. Doesn't
+// take the lock, to not wait for a long maintenance.
+func CheckDb() error {
+	var version int
+	if err := params.Db.QueryRow("SELECT VERSION FROM VERSION").Scan(&version); err != nil {
+		return err
+	}
+	if version != DB_VERSION {
+		return fmt.Errorf("schema version is %d, expected %d", version, DB_VERSION)
+	}
+	return nil
+}
+
+// Checks that a file can be created in dir. A missing dir is checked via
+// its parent, as it's created when needed; returns the dir that was checked.
+func CheckWritable(dir string) (string, error) {
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		dir = filepath.Dir(dir)
+	}
+	f, err := os.CreateTemp(dir, ".seif-probe-*")
+	if err != nil {
+		return dir, err
+	}
+	f.Close()
+	return dir, os.Remove(f.Name())
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package db_ops
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"seif/params"
+	"time"
+)
+
+const SQL_GET_BUCKET = "SELECT TOKENS, TS FROM RATE_LIMITS WHERE BUCKET = $1"
+const SQL_PUT_BUCKET = `
+	INSERT INTO RATE_LIMITS (BUCKET, TOKENS, TS, FULL_AT) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (BUCKET) DO UPDATE SET TOKENS = excluded.TOKENS, TS = excluded.TS, FULL_AT = excluded.FULL_AT`
+
+const SQL_GET_QUOTA = "SELECT BYTES FROM QUOTAS WHERE PRINCIPAL = $1 AND DAY = DATE('now')"
+const SQL_ADD_QUOTA = `
+	INSERT INTO QUOTAS (PRINCIPAL, DAY, BYTES) VALUES ($1, DATE('now'), $2)
+	ON CONFLICT (PRINCIPAL, DAY) DO UPDATE SET BYTES = BYTES + excluded.BYTES`
+
+// Token bucket: takes a token from the bucket, that holds up to capacity
+// tokens and refills at capacity/period. If there's none left, returns
+// how long to wait for the next one.
+func TakeToken(bucket string, capacity int, period time.Duration) (ok bool, retryAfter time.Duration, err error) {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	tx, err := params.Db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	perToken := period / time.Duration(capacity)
+
+	tokens := float64(capacity)
+	var ts int64
+	err = tx.QueryRow(SQL_GET_BUCKET, bucket).Scan(&tokens, &ts)
+	if err == nil {
+		elapsed := now.Sub(time.UnixMilli(ts))
+		tokens = math.Min(float64(capacity), tokens+float64(elapsed)/float64(perToken))
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, err
+	}
+
+	if tokens < 1 {
+		return false, time.Duration((1 - tokens) * float64(perToken)), nil
+	}
+	tokens--
+
+	fullAt := now.Add(time.Duration((float64(capacity) - tokens) * float64(perToken)))
+	if _, err = tx.Exec(SQL_PUT_BUCKET, bucket, tokens, now.UnixMilli(), fullAt.Unix()+1); err != nil {
+		return false, 0, err
+	}
+	return true, 0, tx.Commit()
+}
+
+// Adds bytes to today's (UTC) quota of the principal, unless it would
+// exceed max. q is the transaction that stores what is charged, so that
+// nothing is charged if it fails; the caller holds params.Lock.
+func AddToQuota(q querier, principal string, bytes int, max int) (ok bool, err error) {
+	var used int
+	if err = q.QueryRow(SQL_GET_QUOTA, principal).Scan(&used); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	if used+bytes > max {
+		return false, nil
+	}
+
+	_, err = q.Exec(SQL_ADD_QUOTA, principal, bytes)
+	return err == nil, err
+}
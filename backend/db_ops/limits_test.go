@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package db_ops
+
+import (
+	"path/filepath"
+	"seif/params"
+	"testing"
+	"time"
+)
+
+// Opens a fresh db for the test, closing it at the end
+func openTestDb(t *testing.T) {
+	t.Helper()
+	params.DbPath = filepath.Join(t.TempDir(), "seif.db")
+	Open()
+	t.Cleanup(func() { params.Db.Close() })
+}
+
+func TestTakeToken(t *testing.T) {
+	openTestDb(t)
+
+	// A token every 100ms, bursts of 3
+	const capacity, period = 3, 300 * time.Millisecond
+	take := func(bucket string) (bool, time.Duration) {
+		t.Helper()
+		ok, retryAfter, err := TakeToken(bucket, capacity, period)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok, retryAfter
+	}
+
+	for i := range capacity {
+		if ok, _ := take("a"); !ok {
+			t.Fatalf("token %d refused", i)
+		}
+	}
+	ok, retryAfter := take("a")
+	if ok || retryAfter <= 0 || retryAfter > period/capacity {
+		t.Fatalf("exhausted bucket: got %v, retry after %s", ok, retryAfter)
+	}
+	if ok, _ := take("b"); !ok {
+		t.Error("other bucket: refused")
+	}
+
+	time.Sleep(retryAfter + 10*time.Millisecond)
+	if ok, _ := take("a"); !ok {
+		t.Error("refilled bucket: refused")
+	}
+	if ok, _ := take("a"); ok {
+		t.Error("refilled bucket: more than one token")
+	}
+
+	// Never more than the capacity, however long it waited
+	time.Sleep(period + 50*time.Millisecond)
+	for i := range capacity {
+		if ok, _ := take("a"); !ok {
+			t.Fatalf("full bucket: token %d refused", i)
+		}
+	}
+	if ok, _ := take("a"); ok {
+		t.Error("full bucket: more than the capacity")
+	}
+}
+
+func TestAddToQuota(t *testing.T) {
+	openTestDb(t)
+
+	add := func(principal string, bytes int, commit bool) bool {
+		t.Helper()
+		tx, err := params.Db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		ok, err := AddToQuota(tx, principal, bytes, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit {
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return ok
+	}
+
+	for i, tc := range []struct {
+		principal string
+		bytes     int
+		commit    bool
+		ok        bool
+	}{
+		{"token:a", 60, true, true},
+		{"token:a", 50, true, false},
+		{"token:b", 50, true, true},  // quotas are per principal
+		{"token:a", 40, false, true}, // not committed, so not charged
+		{"token:a", 40, true, true},  // up to the quota included
+		{"token:a", 1, true, false},
+		{"htpasswd:a", 100, true, true}, // and per method
+		{"htpasswd:a", 101, true, false},
+	} {
+		if ok := add(tc.principal, tc.bytes, tc.commit); ok != tc.ok {
+			t.Errorf("%d: %s +%d got %v, expected %v", i, tc.principal, tc.bytes, ok, tc.ok)
+		}
+	}
+}
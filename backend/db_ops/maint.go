@@ -19,8 +19,11 @@
 package db_ops
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"log/slog"
+	"seif/metrics"
 	"seif/params"
 	"seif/utils"
 	"time"
@@ -28,33 +31,109 @@ import (
 
 const maint_period = 5 // min
 
-const SQL_MAINT = "DELETE FROM SECRETS WHERE TS < DATETIME('now', '-' || EXPIRY || ' days')"
+// For time-locked secrets, expiry counts from the moment they can be revealed
+const SQL_MAINT = "SELECT ID FROM SECRETS WHERE COALESCE(NOT_BEFORE, TS) < DATETIME('now', '-' || EXPIRY || ' days')"
 
-func maint(allowToPanic bool) {
+// Full buckets and past days' quotas are the same as missing ones
+const SQL_MAINT_LIMITS = "DELETE FROM RATE_LIMITS WHERE FULL_AT < UNIXEPOCH()"
+const SQL_MAINT_QUOTAS = "DELETE FROM QUOTAS WHERE DAY < DATE('now')"
+
+// Deletes the expired secrets and limits, writes the counted audit events,
+// prunes the audit log and compacts the db. Returns all the errors occurred,
+// joined.
+func Maint() error {
 	// Execute non-concurrently
 	params.Lock.Lock()
 	defer params.Lock.Unlock()
 
-	if _, err := params.Db.Exec(SQL_MAINT); err != nil {
-		if allowToPanic {
-			utils.Abort("in doing cleanup: %s\n", err.Error())
-		} else {
-			fmt.Fprintf(os.Stderr, "in doing maintenance cleanup: %s\n", err.Error())
+	start := time.Now()
+	var errs []error
+	defer func() {
+		metrics.MaintDuration.Observe(time.Since(start).Seconds())
+		recordMaint(errors.Join(errs...))
+		if len(errs) > 0 {
+			metrics.MaintFailures.Inc()
+		}
+	}()
+
+	if err := expire(); err != nil {
+		errs = append(errs, fmt.Errorf("in doing cleanup: %w", err))
+	}
+
+	for _, stmt := range []string{SQL_MAINT_LIMITS, SQL_MAINT_QUOTAS} {
+		if _, err := params.Db.Exec(stmt); err != nil {
+			errs = append(errs, fmt.Errorf("in doing limits cleanup: %w", err))
+		}
+	}
+
+	if err := flushAudit(); err != nil {
+		errs = append(errs, fmt.Errorf("in writing the counted audit events: %w", err))
+	}
+	if params.AuditRetentionDays > 0 {
+		if err := pruneAudit(params.AuditRetentionDays); err != nil {
+			errs = append(errs, fmt.Errorf("in pruning the audit log: %w", err))
 		}
 	}
 
 	if _, err := params.Db.Exec("VACUUM"); err != nil {
-		if allowToPanic {
-			utils.Abort("in doing vacuum: %s\n", err.Error())
-		} else {
-			fmt.Fprintf(os.Stderr, "in doing maintenance vacuum: %s\n", err.Error())
+		errs = append(errs, fmt.Errorf("in doing vacuum: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Deletes the expired secrets one by one, to audit each
+func expire() error {
+	tx, err := params.Db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(SQL_MAINT)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
 		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
 	}
+
+	if err := deleteSecrets(tx, ids, AuditEvent{Event: AUDIT_EXPIRED}, COUNTER_EXPIRED); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	metrics.SecretsExpired.Add(float64(len(ids)))
+	return nil
 }
 
-func StartMaint() {
-	maint(true)
-	for range time.Tick((maint_period * time.Minute)) {
-		maint(false)
+// Runs the maintenance now, then periodically until ctx is done
+func StartMaint(ctx context.Context) {
+	if err := Maint(); err != nil {
+		utils.Abort("%s", err.Error())
+	}
+
+	ticker := time.NewTicker(maint_period * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := Maint(); err != nil {
+				slog.Error("maintenance failed", "error", err)
+			}
+		}
 	}
 }
@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package db_ops
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"seif/params"
+	"seif/utils"
+
+	_ "modernc.org/sqlite"
+)
+
+// Opens the db, creating it if needed. Returns the version of the schema as
+// found, that can be older than DB_VERSION; see UpgradeDb.
+func Open() (dbVersion int, isNew bool) {
+	isNew = !utils.FileExists(params.DbPath)
+
+	// FIXME don't open a new connection for each operation
+	var err error
+	params.Db, err = sql.Open("sqlite", "file:"+params.DbPath+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		panic(err)
+	}
+
+	// Populates db
+	if isNew {
+		InitDb()
+		return DB_VERSION, true
+	}
+
+	// check db version
+
+	row := params.Db.QueryRow("SELECT VERSION FROM VERSION")
+	if err := row.Scan(&dbVersion); err != nil {
+		panic(err)
+	}
+	if dbVersion > DB_VERSION {
+		utils.Abort("DB version is %d but should be %d. Please upgrade the application.", dbVersion, DB_VERSION)
+	}
+	return dbVersion, false
+}
+
+// Opens an existing db, for the command line tools; the db must be at
+// the current version.
+func OpenExisting() {
+	if !utils.FileExists(params.DbPath) {
+		utils.Abort("db %s not found", params.DbPath)
+	}
+	if dbVersion, _ := Open(); dbVersion != DB_VERSION {
+		utils.Abort("DB version is %d but should be %d. Please start the server once, to upgrade it.", dbVersion, DB_VERSION)
+	}
+}
+
+// Closes the db cleanly, at shutdown: waits for the pending backups, runs
+// a last maintenance and checkpoints the WAL, if any.
+func Close() error {
+	WaitBackups()
+
+	var errs []error
+	if err := Maint(); err != nil {
+		errs = append(errs, err)
+	}
+
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	if _, err := params.Db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		errs = append(errs, fmt.Errorf("in checkpointing: %w", err))
+	}
+	if err := params.Db.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("in closing the db: %w", err))
+	}
+	return errors.Join(errs...)
+}
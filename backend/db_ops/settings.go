@@ -0,0 +1,54 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package db_ops
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"seif/params"
+)
+
+const SQL_GET_SETTING = "SELECT VALUE FROM SETTINGS WHERE NAME = $1"
+const SQL_PUT_SETTING = "INSERT INTO SETTINGS (NAME, VALUE) VALUES ($1, $2)"
+
+// Returns a random key persisted in the db under the given name, generating
+// it the first time. Used for the keys that must survive a restart.
+func GetKey(name string, length int) ([]byte, error) {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	var key []byte
+	err := params.Db.QueryRow(SQL_GET_SETTING, name).Scan(&key)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	key = make([]byte, length)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := params.Db.Exec(SQL_PUT_SETTING, name, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
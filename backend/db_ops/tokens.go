@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package db_ops
+
+import (
+	"seif/params"
+)
+
+const SQL_GET_TOKEN = "SELECT NAME FROM API_TOKENS WHERE HASH = $1"
+const SQL_LIST_TOKENS = "SELECT NAME, TS FROM API_TOKENS ORDER BY NAME"
+const SQL_PUT_TOKEN = "INSERT INTO API_TOKENS (NAME, HASH, TS) VALUES ($1, $2, CURRENT_TIMESTAMP)"
+const SQL_DEL_TOKEN = "DELETE FROM API_TOKENS WHERE NAME = $1"
+
+type Token struct {
+	Name string
+	Ts   string
+}
+
+// Returns the name of the token with the given hash, or "" if not found
+func GetTokenName(hash string) (string, error) {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	rows, err := params.Db.Query(SQL_GET_TOKEN, hash)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var name string
+	if rows.Next() {
+		if err := rows.Scan(&name); err != nil {
+			return "", err
+		}
+	}
+	return name, rows.Err()
+}
+
+func ListTokens() ([]Token, error) {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	rows, err := params.Db.Query(SQL_LIST_TOKENS)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []Token
+	for rows.Next() {
+		var t Token
+		if err := rows.Scan(&t.Name, &t.Ts); err != nil {
+			return nil, err
+		}
+		ret = append(ret, t)
+	}
+	return ret, rows.Err()
+}
+
+func PutToken(name, hash string) error {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	_, err := params.Db.Exec(SQL_PUT_TOKEN, name, hash)
+	return err
+}
+
+// Returns false if there was no such token
+func DelToken(name string) (bool, error) {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	res, err := params.Db.Exec(SQL_DEL_TOKEN, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
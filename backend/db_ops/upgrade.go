@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package db_ops
+
+import (
+	"context"
+	"fmt"
+	"seif/params"
+	"seif/utils"
+)
+
+// Element i holds the statements to upgrade the schema from version i+1 to i+2.
+// Never modify an existing element, only append.
+var upgrades = [][]string{
+	{ // 1 -> 2: typed secrets
+		"ALTER TABLE SECRETS ADD COLUMN FORMAT INTEGER NOT NULL DEFAULT 0",
+	},
+	{ // 2 -> 3: time-locked secrets
+		"ALTER TABLE SECRETS ADD COLUMN NOT_BEFORE TEXT",
+	},
+	{ // 3 -> 4: authentication
+		"CREATE TABLE API_TOKENS (NAME TEXT PRIMARY KEY NOT NULL, HASH TEXT NOT NULL UNIQUE, TS TEXT)",
+		"CREATE TABLE SETTINGS (NAME TEXT PRIMARY KEY NOT NULL, VALUE BLOB NOT NULL)",
+	},
+	{ // 4 -> 5: rate limits and quotas
+		"CREATE TABLE RATE_LIMITS (BUCKET TEXT PRIMARY KEY NOT NULL, TOKENS REAL NOT NULL, TS INTEGER NOT NULL, FULL_AT INTEGER NOT NULL)",
+		"CREATE TABLE QUOTAS (PRINCIPAL TEXT NOT NULL, DAY TEXT NOT NULL, BYTES INTEGER NOT NULL, PRIMARY KEY (PRINCIPAL, DAY))",
+	},
+	{ // 5 -> 6: admin stats
+		"CREATE TABLE COUNTERS (NAME TEXT PRIMARY KEY NOT NULL, VALUE INTEGER NOT NULL)",
+	},
+	{ // 6 -> 7: audit log
+		`CREATE TABLE AUDIT (
+			SEQ INTEGER PRIMARY KEY NOT NULL,
+			TS TEXT NOT NULL,
+			EVENT TEXT NOT NULL,
+			ID_HASH TEXT NOT NULL,
+			IP TEXT NOT NULL,
+			PRINCIPAL TEXT NOT NULL,
+			PREV TEXT NOT NULL,
+			HASH TEXT NOT NULL
+		)`,
+		"CREATE TRIGGER AUDIT_NO_UPDATE BEFORE UPDATE ON AUDIT BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END",
+		"CREATE TRIGGER AUDIT_NO_DELETE BEFORE DELETE ON AUDIT BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END",
+	},
+	{ // 7 -> 8: separate key delivery
+		"ALTER TABLE SECRETS ADD COLUMN SEPARATE_KEY INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE SECRETS ADD COLUMN FINGERPRINT TEXT",
+	},
+	{ // 8 -> 9: counted audit events, audit log retention
+		"ALTER TABLE AUDIT ADD COLUMN COUNT INTEGER NOT NULL DEFAULT 1",
+		"CREATE TABLE AUDIT_CHECKPOINTS (SEQ INTEGER PRIMARY KEY NOT NULL, HASH TEXT NOT NULL, TS TEXT NOT NULL)",
+		"CREATE TRIGGER AUDIT_CHECKPOINTS_NO_UPDATE BEFORE UPDATE ON AUDIT_CHECKPOINTS BEGIN SELECT RAISE(ABORT, 'audit checkpoints are append-only'); END",
+		"CREATE TRIGGER AUDIT_CHECKPOINTS_NO_DELETE BEFORE DELETE ON AUDIT_CHECKPOINTS BEGIN SELECT RAISE(ABORT, 'audit checkpoints are append-only'); END",
+		// Only the records up to a checkpoint can be deleted
+		"DROP TRIGGER AUDIT_NO_DELETE",
+		`CREATE TRIGGER AUDIT_NO_DELETE BEFORE DELETE ON AUDIT
+			WHEN OLD.SEQ > (SELECT COALESCE(MAX(SEQ), 0) FROM AUDIT_CHECKPOINTS)
+			BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+	},
+}
+
+var DB_VERSION = len(upgrades) + 1
+
+func UpgradeDb(from int) {
+	// Execute non-concurrently
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	tx, err := params.Db.BeginTx(context.Background(), nil)
+	if err != nil {
+		utils.Abort("in upgrading db: %s", err)
+	}
+	defer tx.Rollback()
+
+	for v := from; v < DB_VERSION; v++ {
+		for _, stmt := range upgrades[v-1] {
+			if _, err := tx.Exec(stmt); err != nil {
+				utils.Abort("in upgrading db to version %d: %s", v+1, err)
+			}
+		}
+	}
+
+	if _, err := tx.Exec("DROP VIEW VERSION"); err != nil {
+		utils.Abort("in upgrading db: %s", err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf(SQL_CREATE_VERSION, DB_VERSION)); err != nil {
+		utils.Abort("in upgrading db: %s", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.Abort("in upgrading db: %s", err)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Where each setting came from: "default", "file", "env" or "flag"
+var sources = map[string]string{}
+
+// Settings whose value is not shown by Effective
+var secretSettings = map[string]bool{"oidc-client-secret": true}
+
+// SEIF_MAX_DAYS for max-days
+func envName(name string) string {
+	return "SEIF_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Applies, in order of precedence, the command line, the environment and
+// the config file; the defaults are already in the flags.
+func layer(fs *flag.FlagSet, args []string, configPath *string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) { sources[f.Name] = "flag" })
+
+	path := *configPath
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+	fileValues, err := readConfigFile(fs, path)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if sources[f.Name] != "" || f.Name == "config" {
+			return
+		}
+		source, value := "default", ""
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			source, value = "env", v
+		} else if v, ok := fileValues[f.Name]; ok {
+			source, value = "file", v
+		}
+		sources[f.Name] = source
+		if source == "default" {
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s (from %s): invalid value \"%s\"", f.Name, source, value))
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// Reads the settings in a TOML file as strings, to be set in the flags.
+// Keys are the flags' names, with dashes or underscores; lists are joined
+// with commas.
+func readConfigFile(fs *flag.FlagSet, path string) (map[string]string, error) {
+	ret := map[string]string{}
+	if path == "" {
+		return ret, nil
+	}
+
+	var file map[string]any
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, fmt.Errorf("in reading config file: %w", err)
+	}
+
+	var errs []string
+	for key, value := range file {
+		name := strings.ReplaceAll(key, "_", "-")
+		if fs.Lookup(name) == nil || name == "config" {
+			errs = append(errs, fmt.Sprintf("%s: unknown setting '%s'", path, key))
+			continue
+		}
+		str, err := tomlString(value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s: %s", path, key, err))
+			continue
+		}
+		ret[name] = str
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return ret, nil
+}
+
+func tomlString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		var items []string
+		for _, item := range v {
+			str, err := tomlString(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// A setting, as resolved by Load
+type Setting struct {
+	Name   string
+	Value  any
+	Source string
+}
+
+// The effective settings after Load, sorted by name, with secrets masked
+func Effective() []Setting {
+	var ret []Setting
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		value := f.Value.(flag.Getter).Get()
+		if secretSettings[f.Name] && value != "" {
+			value = "********"
+		}
+		ret = append(ret, Setting{Name: f.Name, Value: value, Source: sources[f.Name]})
+	})
+	return ret
+}
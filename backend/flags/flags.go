@@ -20,21 +20,166 @@ package flags
 
 import (
 	"flag"
+	"os"
 	"seif/params"
+	"seif/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
 )
 
+// Parses the command line, layered over the config file and environment,
+// into params. Aborts if the result is invalid.
 func Parse() {
+	if err := Load(os.Args[1:]); err != nil {
+		utils.Abort("invalid configuration:\n%s", err)
+	}
+}
+
+// Like Parse, but returns the validation errors
+func Load(args []string) error {
+	_config := flag.String("config", "", "TOML config file, with settings named as these flags (also SEIF_CONFIG)")
 	_db := flag.String("db", "./seif.db", "The path of the sqlite database")
 	_port := flag.Int("port", 34543, "Port")
+	_listen := flag.String("listen", "", "Comma-separated addresses to listen on, as host:port or unix:/path/to.sock, instead of all interfaces on -port")
+	_socketMode := flag.String("socket-mode", "", "Permissions of the unix sockets, in octal (e.g. 0660)")
+	_socketOwner := flag.String("socket-owner", "", "Owner of the unix sockets, as user, user:group or :group")
+	_trustedProxies := flag.String("trusted-proxies", "", "Comma-separated IPs or CIDRs of the reverse proxies to trust, and 'unix' for unix sockets")
+	_clientIpHeader := flag.String("client-ip-header", "", "Header with the client IP set by trusted proxies: X-Forwarded-For, X-Real-IP or Forwarded")
+	_proxyProtocol := flag.Bool("proxy-protocol", false, "Accept the PROXY protocol (v1 or v2) from trusted proxies")
 	_maxDays := flag.Int("max-days", 3, "Maximum retention days to allow")
 	_defaultDays := flag.Int("default-days", 3, "Default retention days to allow, proposed in GUI")
 	_maxBytes := flag.Int("max-bytes", 1024, "Maximum size, in bytes, of a secret")
+	_maxDelayDays := flag.Int("max-delay-days", 30, "Maximum days in the future a secret can be time-locked to")
+	_expiryPresets := flag.String("expiry-presets", "", "Comma-separated expiry days proposed to clients (default: 1, 3, 7, 14, 30 and max-days, up to max-days)")
+	_authTokensFile := flag.String("auth-tokens-file", "", "File with the API tokens allowed to create secrets, as 'name:sha256-hex' lines")
+	_authDbTokens := flag.Bool("auth-db-tokens", false, "Allow the API tokens in the db to create secrets (see 'seif token')")
+	_authHtpasswd := flag.String("auth-htpasswd", "", "htpasswd file (bcrypt or SHA1) with the users allowed to create secrets")
+	_oidcIssuer := flag.String("oidc-issuer", "", "URL of the OIDC issuer, to allow its users to create secrets")
+	_oidcClientId := flag.String("oidc-client-id", "", "OIDC client id")
+	_oidcClientSecret := flag.String("oidc-client-secret", "", "OIDC client secret")
+	_oidcRedirectUrl := flag.String("oidc-redirect-url", "", "OIDC redirect URL, e.g. https://seif.example.com/auth/callback")
+	_limitCreate := flag.String("limit-create", "", "Rate limit for creating secrets, per client IP, as count/period (e.g. 10/m, 100/d)")
+	_limitReveal := flag.String("limit-reveal", "", "Rate limit for revealing secrets, per client IP, as count/period")
+	_limitStatus := flag.String("limit-status", "", "Rate limit for checking secrets' status, per client IP, as count/period")
+	_limitQr := flag.String("limit-qr", "", "Rate limit for rendering QR codes, per client IP, as count/period")
+	_limitTokenCreate := flag.String("limit-token-create", "", "Rate limit for creating secrets, per API token or user, as count/period")
+	_quotaTokenBytes := flag.Int("quota-token-bytes", 0, "Maximum bytes per day that an API token or user can store, 0 for no limit")
+	_csp := flag.String("csp", "auto", "Content-Security-Policy header; 'auto' for a strict one, computed for the UI")
+	_referrerPolicy := flag.String("referrer-policy", "no-referrer", "Referrer-Policy header")
+	_frameOptions := flag.String("frame-options", "DENY", "X-Frame-Options header")
+	_permissionsPolicy := flag.String("permissions-policy", "camera=(), microphone=(), geolocation=(), payment=(), usb=(), clipboard-write=(self)", "Permissions-Policy header")
+	_hstsMaxAge := flag.Int("hsts-max-age", 31536000, "max-age of the Strict-Transport-Security header, sent over HTTPS; 0 to disable")
+	_apiCacheControl := flag.String("api-cache-control", "no-store", "Cache-Control header for the /api responses")
+	_staticOverlay := flag.String("static-overlay", "", "Directory whose files shadow the embedded UI ones, e.g. for a logo or a custom index.html")
+	_theme := flag.String("theme", "", "JSON file with the branding of the UI: product name, tagline, colors, footer, legal link, instructions")
+	_metricsListen := flag.String("metrics-listen", "", "Address (e.g. 127.0.0.1:9090) to serve /metrics on; otherwise it is on the main port for admins only, if auth is enabled")
+	_tlsCert := flag.String("tls-cert", "", "TLS certificate file (PEM), to serve HTTPS; reloaded on SIGHUP or when it changes")
+	_tlsKey := flag.String("tls-key", "", "TLS private key file (PEM)")
+	_tlsClientCa := flag.String("tls-client-ca", "", "CA file (PEM) for the client certificates required by the admin API")
+	_httpRedirectPort := flag.Int("http-redirect-port", 0, "Port to serve plain HTTP on, redirecting to HTTPS; 0 to disable")
+	_acmeDomain := flag.String("acme-domain", "", "Comma-separated domains to get certificates for via ACME, instead of -tls-cert and -tls-key")
+	_acmeDirectory := flag.String("acme-directory", acme.LetsEncryptURL, "ACME directory URL")
+	_acmeEmail := flag.String("acme-email", "", "Contact email for the ACME account")
+	_acmeCaRoot := flag.String("acme-ca-root", "", "CA file (PEM) to trust for the ACME directory, e.g. for a local Pebble")
+	_shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "On SIGTERM or SIGINT, how long to wait for the requests in progress")
+	_logFormat := flag.String("log-format", "text", "Log format, 'text' or 'json'")
+	_logLevel := flag.String("log-level", "info", "Minimum log level: debug, info, warn or error")
+	_readyMinFreeMb := flag.Int("ready-min-free-mb", 64, "Free disk space, in MiB, needed for the db and backups dirs for /readyz to succeed")
+	_auditRetentionDays := flag.Int("audit-retention-days", 90, "Days to keep the audit log records, 0 to keep them forever")
+	_adminUsers := flag.String("admin-users", "", "Comma-separated users allowed to use the admin API, as method:name (e.g. token:ci, htpasswd:bob, or oidc:<sub> with the subject the IdP assigns)")
+
+	if err := layer(flag.CommandLine, args, _config); err != nil {
+		return err
+	}
 
-	flag.Parse()
+	// The default for default-days is clamped to max-days, as it always was;
+	// only an explicit one that exceeds it is an error
+	if sources["default-days"] == "default" {
+		*_defaultDays = min(*_defaultDays, *_maxDays)
+	}
 
 	params.DbPath = *_db
 	params.Port = *_port
+	if *_listen != "" {
+		params.Listen = strings.Split(*_listen, ",")
+	}
+	params.SocketMode = *_socketMode
+	params.SocketOwner = *_socketOwner
+	if *_trustedProxies != "" {
+		params.TrustedProxies = strings.Split(*_trustedProxies, ",")
+	}
+	params.ClientIpHeader = *_clientIpHeader
+	params.ProxyProtocol = *_proxyProtocol
 	params.MaxDays = *_maxDays
-	params.DefaultDays = min(*_defaultDays, *_maxDays)
+	params.DefaultDays = *_defaultDays
 	params.MaxBytes = *_maxBytes
+	params.MaxDelayDays = *_maxDelayDays
+	params.ExpiryPresets = parseDays(*_expiryPresets)
+	params.AuthTokensFile = *_authTokensFile
+	params.AuthDbTokens = *_authDbTokens
+	params.AuthHtpasswd = *_authHtpasswd
+	params.OidcIssuer = *_oidcIssuer
+	params.OidcClientId = *_oidcClientId
+	params.OidcClientSecret = *_oidcClientSecret
+	params.OidcRedirectUrl = *_oidcRedirectUrl
+	params.LimitCreate = *_limitCreate
+	params.LimitReveal = *_limitReveal
+	params.LimitStatus = *_limitStatus
+	params.LimitQr = *_limitQr
+	params.LimitTokenCreate = *_limitTokenCreate
+	params.QuotaTokenBytes = *_quotaTokenBytes
+	params.Csp = *_csp
+	params.ReferrerPolicy = *_referrerPolicy
+	params.FrameOptions = *_frameOptions
+	params.PermissionsPolicy = *_permissionsPolicy
+	params.HstsMaxAge = *_hstsMaxAge
+	params.ApiCacheControl = *_apiCacheControl
+	params.StaticOverlay = *_staticOverlay
+	params.ThemeFile = *_theme
+	params.MetricsListen = *_metricsListen
+	params.ReadyMinFreeMb = *_readyMinFreeMb
+	params.AuditRetentionDays = *_auditRetentionDays
+	params.TlsCert = *_tlsCert
+	params.TlsKey = *_tlsKey
+	params.TlsClientCa = *_tlsClientCa
+	params.HttpRedirectPort = *_httpRedirectPort
+	if *_acmeDomain != "" {
+		params.AcmeDomains = strings.Split(*_acmeDomain, ",")
+	}
+	params.AcmeDirectory = *_acmeDirectory
+	params.AcmeEmail = *_acmeEmail
+	params.AcmeCaRoot = *_acmeCaRoot
+	params.ShutdownTimeout = *_shutdownTimeout
+	params.LogFormat = *_logFormat
+	params.LogLevel = *_logLevel
+	if *_adminUsers != "" {
+		for _, u := range strings.Split(*_adminUsers, ",") {
+			params.AdminUsers = append(params.AdminUsers, strings.TrimSpace(u))
+		}
+	}
+
+	return validate()
+}
+
+// Days, from a comma-separated list; the malformed ones become 0, that
+// validate refuses. Without a list, the common ones up to max-days.
+func parseDays(list string) []int {
+	var ret []int
+	if list == "" {
+		for _, d := range []int{1, 3, 7, 14, 30, params.MaxDays} {
+			if d <= params.MaxDays && !slices.Contains(ret, d) {
+				ret = append(ret, d)
+			}
+		}
+		return ret
+	}
+	for _, item := range strings.Split(list, ",") {
+		d, _ := strconv.Atoi(strings.TrimSpace(item))
+		ret = append(ret, d)
+	}
+	return ret
 }
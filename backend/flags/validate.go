@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package flags
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"seif/limiter"
+	"seif/params"
+	"seif/utils"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Checks the consistency of the settings; returns all the problems found
+func validate() error {
+	var errs []error
+	check := func(ok bool, msg string, a ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(msg, a...))
+		}
+	}
+
+	check(params.Port > 0 && params.Port < 65536, "port: must be between 1 and 65535")
+	if params.SocketMode != "" {
+		_, err := strconv.ParseUint(params.SocketMode, 8, 32)
+		check(err == nil, "socket-mode: must be in octal, e.g. 0660")
+	}
+	switch params.ClientIpHeader {
+	case "", "X-Forwarded-For", "X-Real-IP", "Forwarded":
+	default:
+		check(false, "client-ip-header: must be X-Forwarded-For, X-Real-IP or Forwarded")
+	}
+	check(len(params.TrustedProxies) > 0 || (params.ClientIpHeader == "" && !params.ProxyProtocol), "client-ip-header and proxy-protocol: need trusted-proxies")
+
+	check(params.MaxDays >= 1, "max-days: must be at least 1")
+	check(params.DefaultDays >= 1, "default-days: must be at least 1")
+	check(params.DefaultDays <= params.MaxDays, "default-days (%d) can't be more than max-days (%d)", params.DefaultDays, params.MaxDays)
+	check(params.MaxBytes >= 1, "max-bytes: must be at least 1")
+	check(params.MaxDelayDays >= 0, "max-delay-days: can't be negative")
+	presetsOk := len(params.ExpiryPresets) > 0
+	for _, d := range params.ExpiryPresets {
+		presetsOk = presetsOk && d >= 1 && d <= params.MaxDays
+	}
+	check(presetsOk, "expiry-presets: must be numbers of days between 1 and max-days (%d)", params.MaxDays)
+	for _, limit := range []struct{ name, spec string }{
+		{"limit-create", params.LimitCreate},
+		{"limit-reveal", params.LimitReveal},
+		{"limit-status", params.LimitStatus},
+		{"limit-qr", params.LimitQr},
+		{"limit-token-create", params.LimitTokenCreate},
+	} {
+		_, err := limiter.ParseRate(limit.spec)
+		check(err == nil, "%s: %v", limit.name, err)
+	}
+	check(params.QuotaTokenBytes >= 0, "quota-token-bytes: can't be negative")
+	check(params.ReadyMinFreeMb >= 0, "ready-min-free-mb: can't be negative")
+	check(params.AuditRetentionDays >= 0, "audit-retention-days: can't be negative")
+	if params.StaticOverlay != "" {
+		st, err := os.Stat(params.StaticOverlay)
+		check(err == nil && st.IsDir(), "static-overlay: %s is not a directory", params.StaticOverlay)
+	}
+	check(params.ThemeFile == "" || utils.FileExists(params.ThemeFile), "theme: %s not found", params.ThemeFile)
+
+	for _, u := range params.AdminUsers {
+		method, name, _ := strings.Cut(u, ":")
+		check(slices.Contains([]string{"token", "htpasswd", "oidc"}, method) && name != "", "admin-users: '%s' must be method:name, with method one of token, htpasswd, oidc", u)
+	}
+
+	if params.OidcIssuer != "" {
+		check(params.OidcClientId != "", "oidc-client-id: needed with oidc-issuer")
+		check(params.OidcRedirectUrl != "", "oidc-redirect-url: needed with oidc-issuer")
+	}
+
+	hasFiles := params.TlsCert != "" || params.TlsKey != ""
+	hasAcme := len(params.AcmeDomains) > 0
+	check(!hasFiles || (params.TlsCert != "" && params.TlsKey != ""), "tls-cert and tls-key: both are needed")
+	check(!hasFiles || !hasAcme, "acme-domain: can't be used with tls-cert and tls-key")
+	if !hasFiles && !hasAcme {
+		check(params.TlsClientCa == "", "tls-client-ca: needs TLS")
+		check(params.HttpRedirectPort == 0, "http-redirect-port: needs TLS")
+	}
+	check(params.HttpRedirectPort >= 0 && params.HttpRedirectPort < 65536, "http-redirect-port: must be between 0 and 65535")
+	check(params.HttpRedirectPort != params.Port, "http-redirect-port: must be different from port")
+
+	check(params.HstsMaxAge >= 0, "hsts-max-age: can't be negative")
+	check(params.ShutdownTimeout >= 0, "shutdown-timeout: can't be negative")
+
+	check(params.LogFormat == "text" || params.LogFormat == "json", "log-format: must be 'text' or 'json'")
+	var lvl slog.Level
+	check(lvl.UnmarshalText([]byte(params.LogLevel)) == nil, "log-level: must be debug, info, warn or error")
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package admin
+
+import (
+	"seif/audit"
+	"seif/db_ops"
+	"seif/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type purgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+type taskResponse struct {
+	DurationMs int64 `json:"duration_ms"`
+}
+
+func Stats(c *fiber.Ctx) error {
+	stats, err := db_ops.GetStats()
+	if err != nil {
+		return utils.SendError(c, utils.FHE001, "stats", &err)
+	}
+
+	c.JSON(stats)
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func ListSecrets(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	offset := c.QueryInt("offset", 0)
+	if limit < 1 || offset < 0 {
+		return utils.SendError(c, utils.FHE004, "limit or offset", nil)
+	}
+
+	list, err := db_ops.ListSecrets(limit, offset)
+	if err != nil {
+		return utils.SendError(c, utils.FHE001, "secrets", &err)
+	}
+
+	c.JSON(list)
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func PurgeSecret(c *fiber.Ctx) error {
+	n, err := db_ops.PurgeSecrets(c.Params("id"), 0, audit.Event(c, "", ""))
+	if err != nil {
+		return utils.SendError(c, utils.FHE009, "secret", &err)
+	}
+	if n == 0 {
+		return utils.SendError(c, utils.FHE017, "secret", nil)
+	}
+
+	c.JSON(purgeResponse{Purged: 1})
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// Purges all the secrets created before ?older_than=<duration>, e.g. 36h or 2d
+func PurgeSecrets(c *fiber.Ctx) error {
+	age, err := utils.ParseDuration(c.Query("older_than"))
+	if err != nil {
+		return utils.SendError(c, utils.FHE004, "older_than", &err)
+	}
+	if age < 0 {
+		return utils.SendError(c, utils.FHE004, "older_than", nil)
+	}
+
+	n, err := db_ops.PurgeSecrets("", age, audit.Event(c, "", ""))
+	if err != nil {
+		return utils.SendError(c, utils.FHE009, "secrets", &err)
+	}
+
+	c.JSON(purgeResponse{Purged: n})
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func Maint(c *fiber.Ctx) error {
+	start := time.Now()
+	if err := db_ops.Maint(); err != nil {
+		return utils.SendError(c, utils.FHE008, "maintenance", &err)
+	}
+
+	c.JSON(taskResponse{DurationMs: time.Since(start).Milliseconds()})
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func Backup(c *fiber.Ctx) error {
+	start := time.Now()
+	if err := db_ops.Backup(); err != nil {
+		return utils.SendError(c, utils.FHE008, "backup", &err)
+	}
+
+	c.JSON(taskResponse{DurationMs: time.Since(start).Milliseconds()})
+	return c.SendStatus(fiber.StatusOK)
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package api_v2
+
+import (
+	"encoding/json"
+	"net/url"
+	"seif/bots"
+	"seif/crypton"
+	"seif/payload"
+	"seif/secrets"
+	"seif/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	futils "github.com/gofiber/fiber/v2/utils"
+)
+
+// Resource-style API: secrets are created with POST /secrets, checked with
+// GET /secrets/{id} and revealed with POST /secrets/{id}/reveal, the key
+// travelling in the body. Errors have a stable code, see utils.SendError.
+
+type createRequest struct {
+	Secret string          `json:"secret"`
+	Type   string          `json:"type"`
+	Fields json.RawMessage `json:"fields"`
+	Expiry int             `json:"expiry"` // days
+	// Optional, RFC3339; the secret can't be revealed before it
+	NotBefore   *time.Time `json:"not_before"`
+	SeparateKey bool       `json:"separate_key"`
+	Fingerprint bool       `json:"fingerprint"`
+}
+
+// With a separate key, the link doesn't carry it
+type createResponse struct {
+	Id          string `json:"id"`
+	Key         string `json:"key"`
+	Link        string `json:"link"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+type statusResponse struct {
+	Id          string  `json:"id"`
+	Scheduled   bool    `json:"scheduled"`
+	NotBefore   *string `json:"not_before,omitempty"`
+	OpensIn     *int    `json:"opens_in,omitempty"` // seconds
+	SeparateKey bool    `json:"separate_key"`
+	Fingerprint string  `json:"fingerprint,omitempty"`
+}
+
+type nonceResponse struct {
+	Nonce     string `json:"nonce"`
+	ExpiresIn int    `json:"expires_in"` // seconds
+}
+
+type revealRequest struct {
+	Key   string `json:"key"`
+	Nonce string `json:"nonce"`
+}
+
+type revealResponse struct {
+	Secret string           `json:"secret"`
+	Type   string           `json:"type"`
+	Fields *json.RawMessage `json:"fields,omitempty"`
+}
+
+func CreateSecret(c *fiber.Ctx) error {
+	req := new(createRequest)
+	if err := c.BodyParser(req); err != nil {
+		return utils.SendError(c, utils.FHE004, "body", &err)
+	}
+
+	opts := secrets.Options{NotBefore: req.NotBefore, SeparateKey: req.SeparateKey, Fingerprint: req.Fingerprint}
+	created, e := secrets.Create(c, payload.Secret{Type: req.Type, Text: req.Secret, Fields: req.Fields}, req.Expiry, opts)
+	if e != nil {
+		return e.Send(c)
+	}
+
+	q := url.Values{"t": {created.Id}}
+	if !req.SeparateKey {
+		q.Set("s", created.Key)
+	}
+	c.Location(utils.ApiV2Prefix + "secrets/" + created.Id)
+	c.JSON(createResponse{Id: created.Id, Key: created.Key, Link: c.BaseURL() + "/?" + q.Encode(), Fingerprint: created.Fingerprint})
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// A secret that was revealed, expired or never existed is not found
+func GetSecret(c *fiber.Ctx) error {
+	id := futils.CopyString(c.Params("id"))
+	status, e := secrets.GetStatus(c, id)
+	if e != nil {
+		return e.Send(c)
+	}
+	if !status.Pristine {
+		return utils.SendError(c, utils.FHE017, "secret", nil)
+	}
+
+	ret := statusResponse{Id: id, Scheduled: status.Scheduled, SeparateKey: status.SeparateKey, Fingerprint: status.Fingerprint}
+	if status.Scheduled {
+		notBefore := status.NotBefore.Format(time.RFC3339)
+		opensIn := int(time.Until(status.NotBefore).Seconds()) + 1
+		ret.NotBefore = &notBefore
+		ret.OpensIn = &opensIn
+	}
+
+	c.JSON(ret)
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// As for v1, revealing needs a fresh nonce; see bots.Block
+func CreateRevealNonce(c *fiber.Ctx) error {
+	id := futils.CopyString(c.Params("id"))
+	if _, err := crypton.Str2bs(id); err != nil {
+		return utils.SendError(c, utils.FHE004, "id", &err)
+	}
+
+	c.JSON(nonceResponse{Nonce: bots.NewNonce(id), ExpiresIn: int(bots.NonceTtl.Seconds())})
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func RevealSecret(c *fiber.Ctx) error {
+	id := futils.CopyString(c.Params("id"))
+	req := new(revealRequest)
+	if err := c.BodyParser(req); err != nil {
+		return utils.SendError(c, utils.FHE004, "body", &err)
+	}
+	if !bots.CheckNonce(id, req.Nonce) {
+		return utils.SendError(c, utils.FHE019, "", nil)
+	}
+
+	secret, e := secrets.Reveal(c, id, req.Key, false)
+	if e != nil {
+		return e.Send(c)
+	}
+	if secret == nil {
+		return utils.SendError(c, utils.FHE017, "secret", nil)
+	}
+
+	ret := revealResponse{Secret: secret.Text, Type: secret.Type}
+	if secret.Fields != nil {
+		ret.Fields = &secret.Fields
+	}
+
+	c.JSON(ret)
+	return c.SendStatus(fiber.StatusOK)
+}
@@ -0,0 +1,337 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package api_v2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"image/png"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"seif/bots"
+	"seif/db_ops"
+	"seif/handlers/get_init_data"
+	"seif/params"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func setup(t *testing.T) *fiber.App {
+	t.Helper()
+	params.DbPath = filepath.Join(t.TempDir(), "seif.db")
+	db_ops.Open()
+	// Revealing starts a backup
+	t.Cleanup(func() {
+		db_ops.WaitBackups()
+		params.Db.Close()
+	})
+	if err := db_ops.InitAudit(); err != nil {
+		t.Fatal(err)
+	}
+	bots.Init()
+	params.MaxDays, params.DefaultDays, params.MaxBytes, params.MaxDelayDays = 30, 7, 1024, 30
+
+	// As in main, without the limits
+	app := fiber.New()
+	v2 := app.Group("/api/v2")
+	v2.Get("/openapi.json", OpenApi)
+	v2.Get("/capabilities", get_init_data.GetInitData)
+	v2.Post("/secrets", CreateSecret)
+	v2.Get("/secrets/:id", bots.Block, GetSecret)
+	v2.Post("/secrets/:id/reveal-nonce", bots.Block, CreateRevealNonce)
+	v2.Post("/secrets/:id/reveal", bots.Block, RevealSecret)
+	v2.Post("/qr", QrCode)
+	return app
+}
+
+type httpResult struct {
+	status     int
+	location   string
+	retryAfter string
+}
+
+// Calls the API, decoding the response into out, if given; returns the
+// status and the error code, if any
+func call(t *testing.T, app *fiber.App, method, target string, in, out any) (*httpResult, string) {
+	t.Helper()
+	var body io.Reader
+	if in != nil {
+		bs, _ := json.Marshal(in)
+		body = bytes.NewReader(bs)
+	}
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, _ := io.ReadAll(res.Body)
+
+	var e struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if res.StatusCode >= 400 {
+		if err := json.Unmarshal(bs, &e); err != nil {
+			t.Fatalf("%s %s: error not in JSON: %s", method, target, bs)
+		}
+	} else if out != nil {
+		if err := json.Unmarshal(bs, out); err != nil {
+			t.Fatalf("%s %s: %s", method, target, err)
+		}
+	}
+	return &httpResult{res.StatusCode, res.Header.Get(fiber.HeaderLocation), res.Header.Get(fiber.HeaderRetryAfter)}, e.Error.Code
+}
+
+func reveal(t *testing.T, app *fiber.App, id, key string, out any) (*httpResult, string) {
+	t.Helper()
+	var nonce nonceResponse
+	if res, code := call(t, app, "POST", "/api/v2/secrets/"+id+"/reveal-nonce", nil, &nonce); res.status != fiber.StatusOK {
+		t.Fatalf("reveal-nonce: got %d %s", res.status, code)
+	}
+	return call(t, app, "POST", "/api/v2/secrets/"+id+"/reveal", revealRequest{Key: key, Nonce: nonce.Nonce}, out)
+}
+
+func TestSecretLifecycle(t *testing.T) {
+	app := setup(t)
+
+	var created createResponse
+	res, code := call(t, app, "POST", "/api/v2/secrets", map[string]any{"secret": "hello", "expiry": 1, "fingerprint": true}, &created)
+	if res.status != fiber.StatusCreated || res.location != "/api/v2/secrets/"+created.Id || created.Key == "" || created.Fingerprint == "" {
+		t.Fatalf("create: got %d %s, %+v at %q", res.status, code, created, res.location)
+	}
+	if !strings.HasSuffix(created.Link, "/?s="+created.Key+"&t="+created.Id) {
+		t.Errorf("create: link %s", created.Link)
+	}
+
+	var status statusResponse
+	if res, code := call(t, app, "GET", res.location, nil, &status); res.status != fiber.StatusOK || status.Id != created.Id || status.Fingerprint != created.Fingerprint || status.Scheduled {
+		t.Errorf("status: got %d %s, %+v", res.status, code, status)
+	}
+
+	// A nonce is needed, and only for its secret
+	if res, code := call(t, app, "POST", res.location+"/reveal", revealRequest{Key: created.Key}, nil); res.status != fiber.StatusForbidden || code != "nonce_required" {
+		t.Errorf("no nonce: got %d %s", res.status, code)
+	}
+	var other nonceResponse
+	call(t, app, "POST", "/api/v2/secrets/AAAAAAAAAAAAAAAA/reveal-nonce", nil, &other)
+	if res, code := call(t, app, "POST", res.location+"/reveal", revealRequest{Key: created.Key, Nonce: other.Nonce}, nil); res.status != fiber.StatusForbidden || code != "nonce_required" {
+		t.Errorf("nonce of another secret: got %d %s", res.status, code)
+	}
+
+	// A wrong key doesn't burn it
+	if res, code := reveal(t, app, created.Id, "AAAAAAAA", nil); res.status != fiber.StatusBadRequest || code != "wrong_key" {
+		t.Errorf("wrong key: got %d %s", res.status, code)
+	}
+
+	var revealed revealResponse
+	if res, code := reveal(t, app, created.Id, created.Key, &revealed); res.status != fiber.StatusOK || revealed.Secret != "hello" || revealed.Type != "text" || revealed.Fields != nil {
+		t.Fatalf("reveal: got %d %s, %+v", res.status, code, revealed)
+	}
+
+	// Gone, as if it never existed
+	if res, code := call(t, app, "GET", res.location, nil, nil); res.status != fiber.StatusNotFound || code != "not_found" {
+		t.Errorf("status after reveal: got %d %s", res.status, code)
+	}
+	if res, code := reveal(t, app, created.Id, created.Key, nil); res.status != fiber.StatusNotFound || code != "not_found" {
+		t.Errorf("reveal again: got %d %s", res.status, code)
+	}
+	if res, code := call(t, app, "GET", "/api/v2/secrets/AAAAAAAAAAAAAAAA", nil, nil); res.status != fiber.StatusNotFound || code != "not_found" {
+		t.Errorf("unknown secret: got %d %s", res.status, code)
+	}
+}
+
+func TestTypedAndScheduled(t *testing.T) {
+	app := setup(t)
+
+	var created createResponse
+	notBefore := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	res, code := call(t, app, "POST", "/api/v2/secrets", map[string]any{
+		"type": "credentials", "fields": map[string]string{"username": "bob", "password": "pw"},
+		"expiry": 1, "not_before": notBefore, "separate_key": true,
+	}, &created)
+	if res.status != fiber.StatusCreated || strings.Contains(created.Link, "s=") {
+		t.Fatalf("create: got %d %s, %+v", res.status, code, created)
+	}
+
+	var status statusResponse
+	call(t, app, "GET", res.location, nil, &status)
+	if !status.Scheduled || !status.SeparateKey || status.NotBefore == nil || *status.NotBefore != notBefore.Format(time.RFC3339) || status.OpensIn == nil {
+		t.Errorf("status: %+v", status)
+	}
+
+	res, code = reveal(t, app, created.Id, created.Key, nil)
+	if retryAfter, _ := strconv.Atoi(res.retryAfter); res.status != fiber.StatusLocked || code != "secret_locked" || retryAfter < 1 || retryAfter > 3601 {
+		t.Errorf("reveal before time: got %d %s, retry after %q", res.status, code, res.retryAfter)
+	}
+
+	// Opens now
+	if _, err := params.Db.Exec("UPDATE SECRETS SET NOT_BEFORE = NULL"); err != nil {
+		t.Fatal(err)
+	}
+	var revealed revealResponse
+	if res, code := reveal(t, app, created.Id, created.Key, &revealed); res.status != fiber.StatusOK || revealed.Type != "credentials" || revealed.Fields == nil || revealed.Secret != "Username: bob\nPassword: pw\n" {
+		t.Errorf("reveal: got %d %s, %+v", res.status, code, revealed)
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	app := setup(t)
+
+	for _, tc := range []struct {
+		body any
+		code string
+	}{
+		{"not an object", "bad_request"},
+		{map[string]any{"secret": "hello", "expiry": 31}, "bad_request"},
+		{map[string]any{"type": "totp", "fields": map[string]string{"seed": "!"}, "expiry": 1}, "bad_request"},
+		{map[string]any{"type": "credentials", "fields": nil, "expiry": 1}, "bad_request"},
+	} {
+		var created createResponse
+		if res, code := call(t, app, "POST", "/api/v2/secrets", tc.body, &created); res.status != fiber.StatusBadRequest || code == "" {
+			t.Errorf("%v: got %d %s", tc.body, res.status, code)
+		}
+	}
+}
+
+func TestPreviewsBlocked(t *testing.T) {
+	app := setup(t)
+	req := httptest.NewRequest("POST", "/api/v2/secrets/AAAAAAAAAAAAAAAA/reveal-nonce", nil)
+	req.Header.Set(fiber.HeaderUserAgent, "Mozilla/5.0 (compatible; Discordbot/2.0)")
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusForbidden {
+		t.Errorf("got %d", res.StatusCode)
+	}
+}
+
+var (
+	v2RouteRegexp  = regexp.MustCompile(`^(Get|Post|Put|Patch|Delete)$`)
+	routeParRegexp = regexp.MustCompile(`:(\w+)`)
+)
+
+// The routes of v2 in main.go are those in openapi.json
+func TestQrCode(t *testing.T) {
+	app := setup(t)
+
+	for _, tc := range []struct {
+		req         qrRequest
+		contentType string
+		size        int
+	}{
+		{qrRequest{Id: "AAAAAAAAAAAAAAAA", Key: "AAAAAAAA"}, "image/png", 256},
+		{qrRequest{Id: "AAAAAAAAAAAAAAAA", OmitKey: true, Size: 64}, "image/png", 64},
+		{qrRequest{Id: "AAAAAAAAAAAAAAAA", Key: "AAAAAAAA", Format: "svg", Size: 1024}, "image/svg+xml", 1024},
+	} {
+		bs, _ := json.Marshal(tc.req)
+		req := httptest.NewRequest("POST", "/api/v2/qr", bytes.NewReader(bs))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, _ := io.ReadAll(res.Body)
+		if res.StatusCode != fiber.StatusOK || res.Header.Get(fiber.HeaderContentType) != tc.contentType || res.Header.Get(fiber.HeaderCacheControl) != "no-store" {
+			t.Errorf("%+v: got %d, %s", tc.req, res.StatusCode, res.Header)
+			continue
+		}
+		if tc.contentType == "image/png" {
+			if cfg, err := png.DecodeConfig(bytes.NewReader(img)); err != nil || cfg.Width != tc.size {
+				t.Errorf("%+v: got %+v, %v", tc.req, cfg, err)
+			}
+		} else if !bytes.Contains(img, []byte(fmt.Sprintf(`width="%d"`, tc.size))) {
+			t.Errorf("%+v: got %s", tc.req, img)
+		}
+	}
+
+	for _, req := range []qrRequest{
+		{Id: "not base64!", Key: "AAAAAAAA"},
+		{Id: "AAAAAAAAAAAAAAAA", Key: "not base64!"},
+		{Id: "AAAAAAAAAAAAAAAA", Key: "AAAAAAAA", Size: 32},
+		{Id: "AAAAAAAAAAAAAAAA", Key: "AAAAAAAA", Size: 2048},
+		{Id: "AAAAAAAAAAAAAAAA", Key: "AAAAAAAA", Format: "gif"},
+	} {
+		if res, code := call(t, app, "POST", "/api/v2/qr", req, nil); res.status != fiber.StatusBadRequest || code != "malformed" {
+			t.Errorf("%+v: got %d %s", req, res.status, code)
+		}
+	}
+}
+
+func TestOpenApiMatchesRoutes(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "../../main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var routes []string
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || !v2RouteRegexp.MatchString(sel.Sel.Name) {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); !ok || x.Name != "v2" {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok {
+			return true
+		}
+		path, _ := strconv.Unquote(lit.Value)
+		path = routeParRegexp.ReplaceAllString(path, "{$1}")
+		routes = append(routes, strings.ToLower(sel.Sel.Name)+" "+path)
+		return true
+	})
+
+	var doc struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(openApi, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var documented []string
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			documented = append(documented, method+" "+path)
+		}
+	}
+
+	slices.Sort(routes)
+	slices.Sort(documented)
+	if len(routes) == 0 || !slices.Equal(routes, documented) {
+		t.Errorf("routes in main.go:\n%s\ndocumented:\n%s", strings.Join(routes, "\n"), strings.Join(documented, "\n"))
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package api_v2
+
+import (
+	"net/url"
+	"seif/crypton"
+	"seif/qr"
+	"seif/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The link is built here and never stored; the request is a POST so that
+// the key doesn't end up in logs or histories, like it would in a query.
+type qrRequest struct {
+	Id      string `json:"id"`
+	Key     string `json:"key"`
+	OmitKey bool   `json:"omit_key"` // the key is then given separately
+	Format  string `json:"format"`   // png (default) or svg
+	Size    int    `json:"size"`     // pixels
+}
+
+// Renders the link to a secret as a QR code
+func QrCode(c *fiber.Ctx) error {
+	req := new(qrRequest)
+	if err := c.BodyParser(req); err != nil {
+		return utils.SendError(c, utils.FHE004, "body", &err)
+	}
+	if _, err := crypton.Str2bs(req.Id); err != nil {
+		return utils.SendError(c, utils.FHE004, "id", &err)
+	}
+	q := url.Values{"t": {req.Id}}
+	if !req.OmitKey {
+		if _, err := crypton.Str2bs(req.Key); err != nil {
+			return utils.SendError(c, utils.FHE004, "key", &err)
+		}
+		q.Set("s", req.Key)
+	}
+	if req.Size == 0 {
+		req.Size = qr.DefaultSize
+	}
+	if req.Size < qr.MinSize || req.Size > qr.MaxSize {
+		return utils.SendError(c, utils.FHE004, "size", nil)
+	}
+	link := c.BaseURL() + "/?" + q.Encode()
+
+	var img []byte
+	var err error
+	switch req.Format {
+	case "", qr.FormatPNG:
+		img, err = qr.PNG(link, req.Size)
+		c.Set(fiber.HeaderContentType, "image/png")
+	case qr.FormatSVG:
+		img, err = qr.SVG(link, req.Size)
+		c.Set(fiber.HeaderContentType, "image/svg+xml")
+	default:
+		return utils.SendError(c, utils.FHE004, "format", nil)
+	}
+	if err != nil {
+		return utils.SendError(c, utils.FHE008, "QR code", &err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(fiber.StatusOK).Send(img)
+}
@@ -19,18 +19,86 @@
 package get_init_data
 
 import (
+	"seif/auth"
+	"seif/crypton"
 	"seif/params"
+	"seif/payload"
+	"seif/theme"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// What this instance accepts. Zero means "no limit" for the quota, and "not
+// supported" for the others.
+type limits struct {
+	MaxBytes         int `json:"max_bytes"`
+	MaxDays          int `json:"max_days"`
+	DefaultDays      int `json:"default_days"`
+	MaxDelayDays     int `json:"max_delay_days"`
+	MaxViews         int `json:"max_views"`
+	MaxFileBytes     int `json:"max_file_bytes"`
+	QuotaBytesPerDay int `json:"quota_bytes_per_day"`
+}
+
+// What this instance can do; clients should hide what's false
+type features struct {
+	AuthRequired  bool `json:"auth_required"`
+	Passphrase    bool `json:"passphrase"`
+	Files         bool `json:"files"`
+	ZeroKnowledge bool `json:"zero_knowledge"`
+	Scheduled     bool `json:"scheduled"`
+	SeparateKey   bool `json:"separate_key"`
+	Fingerprint   bool `json:"fingerprint"`
+	TypedSecrets  bool `json:"typed_secrets"`
+	Qr            bool `json:"qr"`
+}
+
+// The capabilities document: the first fields are kept as they were, for
+// clients that predate the rest
 type response struct {
 	Version     string `json:"version"`
 	MaxDays     int    `json:"max_days"`
 	DefaultDays int    `json:"default_days"`
+	// Creating secrets requires authentication; if LoginUrl is set, it's
+	// where to send the browser to log in
+	AuthRequired bool   `json:"auth_required"`
+	LoginUrl     string `json:"login_url,omitempty"`
+	// Branding, if configured
+	Theme *theme.Theme `json:"theme,omitempty"`
+
+	Limits         limits   `json:"limits"`
+	Features       features `json:"features"`
+	ExpiryPresets  []int    `json:"expiry_presets"`
+	SecretTypes    []string `json:"secret_types"`
+	CryptoVersions []string `json:"crypto_versions"`
+	ApiVersions    []string `json:"api_versions"`
 }
 
 func GetInitData(c *fiber.Ctx) error {
-	c.JSON(response{Version: params.VERSION, MaxDays: params.MaxDays, DefaultDays: params.DefaultDays})
+	ret := response{Version: params.VERSION, MaxDays: params.MaxDays, DefaultDays: params.DefaultDays, AuthRequired: auth.Enabled(), Theme: theme.Current}
+	if params.OidcIssuer != "" {
+		ret.LoginUrl = "/auth/login"
+	}
+	ret.Limits = limits{
+		MaxBytes:         params.MaxBytes,
+		MaxDays:          params.MaxDays,
+		DefaultDays:      params.DefaultDays,
+		MaxDelayDays:     params.MaxDelayDays,
+		MaxViews:         1, // secrets always burn on the first reveal
+		QuotaBytesPerDay: params.QuotaTokenBytes,
+	}
+	ret.Features = features{
+		AuthRequired: ret.AuthRequired,
+		Scheduled:    params.MaxDelayDays > 0,
+		SeparateKey:  true,
+		Fingerprint:  true,
+		TypedSecrets: true,
+		Qr:           true,
+	}
+	ret.ExpiryPresets = params.ExpiryPresets
+	ret.SecretTypes = payload.Types
+	ret.CryptoVersions = []string{crypton.SCHEME}
+	ret.ApiVersions = []string{"v1", "v2"}
+	c.JSON(ret)
 	return c.SendStatus(fiber.StatusOK)
 }
@@ -0,0 +1,44 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package get_reveal_nonce
+
+import (
+	"seif/bots"
+	"seif/crypton"
+	"seif/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type response struct {
+	Nonce     string `json:"nonce"`
+	ExpiresIn int    `json:"expires_in"` // seconds
+}
+
+// The page asks for a nonce right before revealing a secret, so that only
+// a user's click can reveal it; see bots.Block
+func GetRevealNonce(c *fiber.Ctx) error {
+	id := c.Query("id", "")
+	if _, err := crypton.Str2bs(id); err != nil {
+		return utils.SendError(c, utils.FHE004, "id", &err)
+	}
+
+	c.JSON(response{Nonce: bots.NewNonce(id), ExpiresIn: int(bots.NonceTtl.Seconds())})
+	return c.SendStatus(fiber.StatusOK)
+}
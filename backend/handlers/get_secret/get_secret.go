@@ -19,81 +19,49 @@
 package get_secret
 
 import (
-	"context"
-	"seif/crypton"
-	"seif/db_ops"
-	"seif/params"
+	"encoding/json"
+	"seif/bots"
+	"seif/secrets"
 	"seif/utils"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// Secret is the plain text, or a rendering of the fields for typed secrets
 type response struct {
-	Secret *string `json:"secret"`
+	Secret *string          `json:"secret"`
+	Type   string           `json:"type,omitempty"`
+	Fields *json.RawMessage `json:"fields,omitempty"`
 }
 
-const SQL1 = "SELECT SECRET FROM SECRETS WHERE ID = $1"
-const SQL2 = "DELETE FROM SECRETS WHERE ID = $1"
+// The key can also be sent in this header, out of the URL; secrets created
+// with a separate key require it
+const HeaderKey = "X-Secret-Key"
 
 func GetSecret(c *fiber.Ctx) error {
 	id := c.Query("id", "")
-	idBs, err := crypton.Str2bs(id)
-	if err != nil {
-		return utils.SendError(c, fiber.StatusBadRequest, utils.FHE004, "id", &err)
+	if !bots.CheckNonce(id, c.Get(bots.HeaderRevealNonce)) {
+		return utils.SendError(c, utils.FHE019, "", nil)
 	}
 
-	key := c.Query("key", "")
-	keyBs, err := crypton.Str2bs(key)
-	if err != nil {
-		return utils.SendError(c, fiber.StatusBadRequest, utils.FHE004, "key", &err)
+	key, keyInUrl := c.Get(HeaderKey), false
+	if key == "" {
+		key, keyInUrl = c.Query("key", ""), true
 	}
-
-	defer func() { go db_ops.Backup() }()
-	params.Lock.Lock()
-	defer params.Lock.Unlock()
-
-	ret := response{}
-
-	tx, err := params.Db.BeginTx(context.Background(), nil)
-	if err != nil {
-		return utils.SendError(c, fiber.StatusInternalServerError, "FHE007", "", &err)
+	secret, e := secrets.Reveal(c, id, key, keyInUrl)
+	if e != nil {
+		return e.Send(c)
 	}
-	defer tx.Rollback()
 
-	rows, err := tx.Query(SQL1, id)
-	if err != nil {
-		return utils.SendError(c, fiber.StatusInternalServerError, utils.FHE001, "secret", &err)
-	}
-	defer rows.Close()
-	if rows.Next() {
-		var secret []byte
-		err = rows.Scan(&secret)
-		if err != nil {
-			return utils.SendError(c, fiber.StatusInternalServerError, utils.FHE001, "secret", &err)
-		}
-
-		plaintxt, err := crypton.Decode(idBs, keyBs, secret)
-		if err != nil {
-			return utils.SendError(c, fiber.StatusBadRequest, utils.FHE008, "decryption", &err)
-		}
-
-		ret.Secret = &plaintxt
-	}
-	if err = rows.Err(); err != nil {
-		return utils.SendError(c, fiber.StatusInternalServerError, utils.FHE003, "secret", &err)
-	}
-
-	if ret.Secret != nil {
-		_, err := tx.Exec(SQL2, id)
-		if err != nil {
-			return utils.SendError(c, fiber.StatusInternalServerError, utils.FHE009, "secret", &err)
+	ret := response{}
+	if secret != nil {
+		ret.Secret = &secret.Text
+		ret.Type = secret.Type
+		if secret.Fields != nil {
+			ret.Fields = &secret.Fields
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return utils.SendError(c, fiber.StatusInternalServerError, utils.FHE009, "transaction", &err)
-	}
-
 	c.JSON(ret)
 	return c.SendStatus(fiber.StatusOK)
 }
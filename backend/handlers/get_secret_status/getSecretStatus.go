@@ -19,34 +19,38 @@
 package get_secret_status
 
 import (
-	"seif/params"
-	"seif/utils"
+	"seif/secrets"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// A scheduled secret is pristine, but time-locked until NotBefore
 type response struct {
-	Pristine bool `json:"pristine"`
+	Pristine  bool    `json:"pristine"`
+	Scheduled bool    `json:"scheduled"`
+	NotBefore *string `json:"not_before,omitempty"`
+	OpensIn   *int    `json:"opens_in,omitempty"` // seconds
+	// The key must be given separately from the link; if Fingerprint is
+	// set, the recipient can check it with the sender
+	SeparateKey bool   `json:"separate_key"`
+	Fingerprint string `json:"fingerprint,omitempty"`
 }
 
-const SQL_GET_SECRET = "SELECT 1 FROM SECRETS WHERE ID = $1"
-
 func GetSecretStatus(c *fiber.Ctx) error {
-	id := c.Query("id", "")
-
-	params.Lock.Lock()
-	defer params.Lock.Unlock()
-
-	rows, err := params.Db.Query(SQL_GET_SECRET, id)
-	if err != nil {
-		return utils.SendError(c, fiber.StatusInternalServerError, utils.FHE001, "secret", &err)
+	status, e := secrets.GetStatus(c, c.Query("id", ""))
+	if e != nil {
+		return e.Send(c)
 	}
-	defer rows.Close()
-	ret := rows.Next()
-	if err = rows.Err(); err != nil {
-		return utils.SendError(c, fiber.StatusInternalServerError, utils.FHE003, "secret", &err)
+
+	ret := response{Pristine: status.Pristine, Scheduled: status.Scheduled, SeparateKey: status.SeparateKey, Fingerprint: status.Fingerprint}
+	if status.Scheduled {
+		notBefore := status.NotBefore.Format(time.RFC3339)
+		opensIn := int(time.Until(status.NotBefore).Seconds()) + 1
+		ret.NotBefore = &notBefore
+		ret.OpensIn = &opensIn
 	}
 
-	c.JSON(response{Pristine: ret})
+	c.JSON(ret)
 	return c.SendStatus(fiber.StatusOK)
 }
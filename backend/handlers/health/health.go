@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package health
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"seif/db_ops"
+	"seif/logging"
+	"seif/params"
+	"seif/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type check struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"` // only on the metrics listener
+}
+
+type readyResponse struct {
+	Ok     bool             `json:"ok"`
+	Checks map[string]check `json:"checks"`
+}
+
+// Liveness: the process is up and serving
+func Healthz(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).SendString("ok")
+}
+
+// Readiness: the db works, and there's room to write it and its backups.
+// The errors, that tell about paths and the db, are only logged.
+func Readyz(c *fiber.Ctx) error {
+	return readyz(c, false)
+}
+
+// Like Readyz, with the errors in the response; for the metrics listener,
+// that isn't public
+func ReadyzDetailed(c *fiber.Ctx) error {
+	return readyz(c, true)
+}
+
+func readyz(c *fiber.Ctx, detailed bool) error {
+	errs := map[string]error{
+		"db":          db_ops.CheckDb(),
+		"db_dir":      checkDir(filepath.Dir(params.DbPath)),
+		"backups_dir": checkDir(db_ops.BackupDir()),
+	}
+
+	maint, backup := db_ops.LastRuns()
+	// Not having run yet is fine: maintenance runs at startup, backups
+	// only when upgrading an existing db or on request
+	if maint != nil {
+		errs["maint"] = maint.Err
+	}
+	if backup != nil {
+		errs["backup"] = backup.Err
+	}
+
+	ret := readyResponse{Ok: true, Checks: map[string]check{}}
+	for name, err := range errs {
+		chk := check{Ok: err == nil}
+		if err != nil {
+			logging.FromCtx(c).Warn("readiness check failed", "check", name, "error", err)
+			if detailed {
+				chk.Error = err.Error()
+			}
+		}
+		ret.Checks[name] = chk
+		ret.Ok = ret.Ok && chk.Ok
+	}
+
+	c.JSON(ret)
+	if !ret.Ok {
+		return c.SendStatus(fiber.StatusServiceUnavailable)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func checkDir(dir string) error {
+	dir, err := db_ops.CheckWritable(dir)
+	if err != nil {
+		return err
+	}
+	free, err := utils.FreeBytes(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if needed := uint64(params.ReadyMinFreeMb) << 20; free < needed {
+		return fmt.Errorf("%d MiB free, less than %d", free>>20, params.ReadyMinFreeMb)
+	}
+	return nil
+}
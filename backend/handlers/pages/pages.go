@@ -0,0 +1,199 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pages
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/url"
+	"seif/auth"
+	"seif/bots"
+	"seif/params"
+	"seif/payload"
+	"seif/secrets"
+	"seif/theme"
+	"seif/utils"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Server-rendered pages, for browsers without JavaScript. They use the same
+// parameters as the UI (t for the ID, s for the key) and plain forms.
+
+//go:embed templates/*.html
+var templatesFs embed.FS
+
+var templates = map[string]*template.Template{}
+
+func init() {
+	for _, name := range []string{"create", "created", "reveal", "revealed", "message"} {
+		templates[name] = template.Must(template.ParseFS(templatesFs, "templates/layout.html", "templates/"+name+".html"))
+	}
+}
+
+// Format of <input type="datetime-local">
+const datetimeLocal = "2006-01-02T15:04"
+
+type page struct {
+	Version string
+	Theme   *theme.Theme
+	// create
+	MaxDays       int
+	DefaultDays   int
+	ExpiryPresets []int
+	Scheduled     bool
+	LoginUrl      string
+	// created
+	Link        string
+	LinkNoJs    string
+	LinkNoKey   string
+	Key         string
+	NotBefore   string
+	SeparateKey bool
+	// reveal too
+	Fingerprint string
+	// reveal and revealed
+	Id     string
+	Nonce  string
+	Secret string
+	// message
+	Title   string
+	Message string
+}
+
+func render(c *fiber.Ctx, status int, name string, p page) error {
+	p.Version = params.VERSION
+	p.Theme = theme.Current
+
+	var buf bytes.Buffer
+	if err := templates[name].ExecuteTemplate(&buf, "layout", p); err != nil {
+		return err
+	}
+
+	// They can contain keys and secrets
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Status(status).Send(buf.Bytes())
+}
+
+func renderError(c *fiber.Ctx, e *secrets.Error) error {
+	if e.RetryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, fmt.Sprint(int(e.RetryAfter.Seconds())+1))
+	}
+	return render(c, e.Def.Status, "message", page{Title: "Error", Message: e.Message(utils.Lang(c))})
+}
+
+func notFound(c *fiber.Ctx) error {
+	return renderError(c, &secrets.Error{Def: utils.FHE017, Object: "secret"})
+}
+
+func Create(c *fiber.Ctx) error {
+	p := page{MaxDays: params.MaxDays, DefaultDays: params.DefaultDays, ExpiryPresets: params.ExpiryPresets, Scheduled: params.MaxDelayDays > 0}
+	// Must come after auth.Optional
+	if params.OidcIssuer != "" && auth.FromCtx(c) == nil {
+		p.LoginUrl = "/auth/login"
+	}
+	return render(c, fiber.StatusOK, "create", p)
+}
+
+func PostCreate(c *fiber.Ctx) error {
+	expiry, err := strconv.Atoi(c.FormValue("expiry"))
+	if err != nil {
+		return renderError(c, &secrets.Error{Def: utils.FHE004, Object: "expiry"})
+	}
+
+	var notBefore *time.Time
+	if nb := c.FormValue("not_before"); nb != "" {
+		t, err := time.Parse(datetimeLocal, nb)
+		if err != nil {
+			return renderError(c, &secrets.Error{Def: utils.FHE004, Object: "reveal time"})
+		}
+		notBefore = &t
+	}
+
+	opts := secrets.Options{NotBefore: notBefore, SeparateKey: c.FormValue("separate_key") != "", Fingerprint: c.FormValue("fingerprint") != ""}
+	created, e := secrets.Create(c, payload.Secret{Text: c.FormValue("secret")}, expiry, opts)
+	if e != nil {
+		return renderError(c, e)
+	}
+
+	q := url.Values{"t": {created.Id}}
+	linkNoKey := c.BaseURL() + "/?" + q.Encode()
+	if !opts.SeparateKey {
+		q.Set("s", created.Key)
+	}
+	p := page{
+		Link:        c.BaseURL() + "/?" + q.Encode(),
+		LinkNoJs:    c.BaseURL() + "/nojs/reveal?" + q.Encode(),
+		LinkNoKey:   linkNoKey,
+		Key:         created.Key,
+		SeparateKey: opts.SeparateKey,
+		Fingerprint: created.Fingerprint,
+	}
+	if notBefore != nil && notBefore.After(time.Now()) {
+		p.NotBefore = notBefore.UTC().Format(time.RFC1123)
+	}
+	return render(c, fiber.StatusOK, "created", p)
+}
+
+// Asks for confirmation, so that opening the link doesn't burn the secret
+func Reveal(c *fiber.Ctx) error {
+	id := c.Query("t")
+	status, e := secrets.GetStatus(c, id)
+	if e != nil {
+		return renderError(c, e)
+	}
+	if !status.Pristine {
+		return notFound(c)
+	}
+	if status.Scheduled {
+		return renderError(c, &secrets.Error{Def: utils.FHE011, Object: status.NotBefore.UTC().Format(time.RFC1123), RetryAfter: time.Until(status.NotBefore)})
+	}
+
+	if status.SeparateKey && c.Query("s") != "" {
+		return renderError(c, &secrets.Error{Def: utils.FHE022})
+	}
+
+	return render(c, fiber.StatusOK, "reveal", page{Id: id, Key: c.Query("s"), Nonce: bots.NewNonce(id), Fingerprint: status.Fingerprint})
+}
+
+func PostReveal(c *fiber.Ctx) error {
+	id := c.FormValue("t")
+	if !bots.CheckNonce(id, c.FormValue("nonce")) {
+		return renderError(c, &secrets.Error{Def: utils.FHE019})
+	}
+
+	// s comes from the link, key is typed by the user
+	key, keyInUrl := c.FormValue("key"), false
+	if key == "" {
+		key, keyInUrl = c.FormValue("s"), true
+	}
+	secret, e := secrets.Reveal(c, id, key, keyInUrl)
+	if e != nil {
+		return renderError(c, e)
+	}
+	if secret == nil {
+		return notFound(c)
+	}
+	return render(c, fiber.StatusOK, "revealed", page{Secret: secret.Text})
+}
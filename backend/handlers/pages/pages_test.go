@@ -0,0 +1,184 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package pages
+
+import (
+	"html"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"seif/auth"
+	"seif/bots"
+	"seif/db_ops"
+	"seif/params"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func setup(t *testing.T) *fiber.App {
+	t.Helper()
+	params.DbPath = filepath.Join(t.TempDir(), "seif.db")
+	db_ops.Open()
+	// Revealing starts a backup
+	t.Cleanup(func() {
+		db_ops.WaitBackups()
+		params.Db.Close()
+	})
+	if err := db_ops.InitAudit(); err != nil {
+		t.Fatal(err)
+	}
+	bots.Init()
+	params.MaxDays, params.DefaultDays, params.MaxBytes, params.ExpiryPresets = 30, 7, 1024, []int{1, 7, 30}
+
+	// As in main
+	app := fiber.New()
+	app.Get("/nojs/", auth.Optional, Create)
+	app.Post("/nojs/create", auth.Required, PostCreate)
+	app.Get("/nojs/reveal", bots.Block, Reveal)
+	app.Post("/nojs/reveal", bots.Block, PostReveal)
+	return app
+}
+
+func do(t *testing.T, app *fiber.App, method, target string, form url.Values, headers ...string) (int, string) {
+	t.Helper()
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req := httptest.NewRequest(method, target, body)
+	if form != nil {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(bs)
+}
+
+var (
+	linkNoJsRegexp = regexp.MustCompile(`id="linkNoJs" value="([^"]+)"`)
+	hiddenRegexp   = regexp.MustCompile(`<input type="hidden" name="(\w+)" value="([^"]*)">`)
+)
+
+// The hidden fields of the reveal form
+func revealForm(t *testing.T, page string) url.Values {
+	t.Helper()
+	form := url.Values{}
+	for _, m := range hiddenRegexp.FindAllStringSubmatch(page, -1) {
+		form.Set(m[1], html.UnescapeString(m[2]))
+	}
+	if form.Get("t") == "" || form.Get("nonce") == "" {
+		t.Fatalf("no reveal form in:\n%s", page)
+	}
+	return form
+}
+
+func TestCreateAndReveal(t *testing.T) {
+	app := setup(t)
+
+	status, page := do(t, app, "POST", "/nojs/create", url.Values{"secret": {"hello <world>"}, "expiry": {"1"}})
+	m := linkNoJsRegexp.FindStringSubmatch(page)
+	if status != fiber.StatusOK || m == nil {
+		t.Fatalf("create: got %d\n%s", status, page)
+	}
+	link, err := url.Parse(html.UnescapeString(m[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Previews can't even see the confirmation page
+	if status, _ := do(t, app, "GET", link.RequestURI(), nil, fiber.HeaderUserAgent, "Slackbot-LinkExpanding 1.0"); status != fiber.StatusForbidden {
+		t.Errorf("preview: got %d", status)
+	}
+
+	status, page = do(t, app, "GET", link.RequestURI(), nil)
+	if status != fiber.StatusOK {
+		t.Fatalf("reveal page: got %d\n%s", status, page)
+	}
+	form := revealForm(t, page)
+	if form.Get("s") != link.Query().Get("s") {
+		t.Errorf("reveal page: key %q", form.Get("s"))
+	}
+
+	forged := url.Values{"t": {form.Get("t")}, "s": {form.Get("s")}, "nonce": {"forged"}}
+	if status, _ := do(t, app, "POST", "/nojs/reveal", forged); status != fiber.StatusForbidden {
+		t.Errorf("forged nonce: got %d", status)
+	}
+
+	status, page = do(t, app, "POST", "/nojs/reveal", form)
+	if status != fiber.StatusOK || !strings.Contains(page, "hello &lt;world&gt;") {
+		t.Fatalf("reveal: got %d\n%s", status, page)
+	}
+
+	// Once only
+	if status, _ := do(t, app, "GET", link.RequestURI(), nil); status != fiber.StatusNotFound {
+		t.Errorf("reveal page again: got %d", status)
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	app := setup(t)
+
+	for _, form := range []url.Values{
+		{"secret": {"hello"}, "expiry": {"x"}},
+		{"secret": {"hello"}, "expiry": {"31"}},
+		{"secret": {"hello"}, "expiry": {"1"}, "not_before": {"tomorrow"}},
+		{"secret": {strings.Repeat("x", 2000)}, "expiry": {"1"}},
+	} {
+		if status, _ := do(t, app, "POST", "/nojs/create", form); status < 400 {
+			t.Errorf("%v: got %d", form, status)
+		}
+	}
+}
+
+func TestLoginLink(t *testing.T) {
+	app := setup(t)
+
+	params.AuthTokensFile = filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(params.AuthTokensFile, []byte("ci:"+auth.HashToken("seif_ci")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auth.Init()
+	params.OidcIssuer = "https://idp.example"
+	t.Cleanup(func() { params.AuthTokensFile, params.OidcIssuer = "", "" })
+
+	for _, tc := range []struct {
+		authorization string
+		link          bool
+	}{
+		{"", true},
+		{"Bearer seif_wrong", true},
+		{"Bearer seif_ci", false},
+	} {
+		status, page := do(t, app, "GET", "/nojs/", nil, fiber.HeaderAuthorization, tc.authorization)
+		if status != fiber.StatusOK || strings.Contains(page, `href="/auth/login"`) != tc.link {
+			t.Errorf("%q: got %d, login link expected %v", tc.authorization, status, tc.link)
+		}
+	}
+}
@@ -19,59 +19,45 @@
 package put_secret
 
 import (
-	"fmt"
-	"seif/crypton"
-	"seif/db_ops"
-	"seif/params"
+	"encoding/json"
+	"seif/payload"
+	"seif/secrets"
 	"seif/utils"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 type request struct {
-	Secret string `json:"secret"`
-	Expiry int    `json:"expiry"`
+	Secret string          `json:"secret"`
+	Type   string          `json:"type"`
+	Fields json.RawMessage `json:"fields"`
+	Expiry int             `json:"expiry"`
+	// Optional, RFC3339; the secret can't be revealed before it
+	NotBefore *time.Time `json:"not_before"`
+	// The key is to be given separately from the link, see secrets.Options
+	SeparateKey bool `json:"separate_key"`
+	Fingerprint bool `json:"fingerprint"`
 }
 
 type response struct {
-	Id  string `json:"id"`
-	Key string `json:"key"`
+	Id          string `json:"id"`
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint,omitempty"`
 }
 
-const SQL = `
-	INSERT INTO SECRETS (ID, SECRET, EXPIRY, TS)
-	VALUES ($1, $2, $3, CURRENT_TIMESTAMP)`
-
 func PutSecret(c *fiber.Ctx) error {
 	req := new(request)
 	if err := c.BodyParser(req); err != nil {
-		return utils.SendError(c, fiber.StatusBadRequest, utils.FHE004, "body", &err)
-	}
-
-	if len(req.Secret) > params.MaxBytes {
-		return utils.SendError(c, fiber.StatusBadRequest, utils.FHE005, "", nil)
-	}
-
-	if req.Expiry < 1 || req.Expiry > params.MaxDays {
-		return utils.SendError(c, fiber.StatusBadRequest, utils.FHE006, fmt.Sprint(params.MaxDays), nil)
+		return utils.SendError(c, utils.FHE004, "body", &err)
 	}
 
-	id, key, crypto, err := crypton.Encode(req.Secret)
-	if err != nil {
-		return utils.SendError(c, fiber.StatusInternalServerError, utils.FHE007, "", &err)
-	}
-
-	ret := response{Id: crypton.Bs2str(id), Key: crypton.Bs2str(key)}
-
-	defer func() { go db_ops.Backup() }()
-	params.Lock.Lock()
-	defer params.Lock.Unlock()
-
-	_, err = params.Db.Exec(SQL, ret.Id, crypto, req.Expiry)
-	if err != nil {
-		return utils.SendError(c, fiber.StatusInternalServerError, utils.FHE002, "secrets", &err)
+	opts := secrets.Options{NotBefore: req.NotBefore, SeparateKey: req.SeparateKey, Fingerprint: req.Fingerprint}
+	created, e := secrets.Create(c, payload.Secret{Type: req.Type, Text: req.Secret, Fields: req.Fields}, req.Expiry, opts)
+	if e != nil {
+		return e.Send(c)
 	}
 
-	c.JSON(ret)
+	c.JSON(response{Id: created.Id, Key: created.Key, Fingerprint: created.Fingerprint})
 	return c.SendStatus(fiber.StatusOK)
 }
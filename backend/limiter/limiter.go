@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package limiter
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"seif/audit"
+	"seif/auth"
+	"seif/db_ops"
+	"seif/params"
+	"seif/proxy"
+	"seif/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A rate, as "count/period": at most count requests in period, with bursts
+// up to count
+type Rate struct {
+	count  int
+	period time.Duration
+}
+
+// Rates by route and by client kind (ip or principal); missing means unlimited
+var rates = map[string]Rate{}
+
+// Parses "10/1m", "100/h", "1000/d"; "" means no limit
+func ParseRate(spec string) (*Rate, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	c, p, ok := strings.Cut(spec, "/")
+	if !ok {
+		return nil, errors.New("must be in the form count/period")
+	}
+	count, err := strconv.Atoi(c)
+	if err != nil || count < 1 {
+		return nil, errors.New("count must be a positive integer")
+	}
+	if p == "d" || p == "1d" {
+		p = "24h"
+	} else if p == "s" || p == "m" || p == "h" {
+		p = "1" + p
+	}
+	period, err := time.ParseDuration(p)
+	if err != nil || period <= 0 {
+		return nil, errors.New("period must be a positive duration, e.g. 30s, 1m, h, d")
+	}
+	return &Rate{count: count, period: period}, nil
+}
+
+// Sets up the configured rates, that the flags validation already checked
+func Init() {
+	for key, spec := range map[string]string{
+		"create:ip":        params.LimitCreate,
+		"reveal:ip":        params.LimitReveal,
+		"status:ip":        params.LimitStatus,
+		"qr:ip":            params.LimitQr,
+		"create:principal": params.LimitTokenCreate,
+	} {
+		if r, _ := ParseRate(spec); r != nil {
+			rates[key] = *r
+		}
+	}
+}
+
+func take(c *fiber.Ctx, key, client string) error {
+	r, ok := rates[key]
+	if !ok {
+		return c.Next()
+	}
+
+	ok, retryAfter, err := db_ops.TakeToken(key+":"+client, r.count, r.period)
+	if err != nil {
+		return utils.SendError(c, utils.FHE008, "rate limiting", &err)
+	}
+	if !ok {
+		audit.Count(c, db_ops.AUDIT_RATE_LIMITED)
+		c.Set(fiber.HeaderRetryAfter, fmt.Sprint(int(retryAfter.Seconds())+1))
+		return utils.SendError(c, utils.FHE014, "", nil)
+	}
+	return c.Next()
+}
+
+// Middleware that limits the requests to a route ("create", "reveal",
+// "status" or "qr") by client IP
+func ByIp(route string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return take(c, route+":ip", proxy.ClientIP(c))
+	}
+}
+
+// Middleware that limits the requests to a route by authenticated principal
+// (e.g. API token); must come after auth.Required
+func ByPrincipal(route string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p := auth.FromCtx(c)
+		if p == nil {
+			return c.Next()
+		}
+		return take(c, route+":principal", p.Method+":"+p.Name)
+	}
+}
+
+// Adds bytes to the daily quota of the authenticated principal, if any,
+// in tx, that is the one storing the secret. If exceeded, returns false and
+// when the quota resets; auditing it is up to the caller, that holds
+// params.Lock.
+func CheckQuota(c *fiber.Ctx, tx *sql.Tx, bytes int) (ok bool, retryAfter time.Duration, err error) {
+	p := auth.FromCtx(c)
+	if p == nil || params.QuotaTokenBytes <= 0 {
+		return true, 0, nil
+	}
+
+	ok, err = db_ops.AddToQuota(tx, p.Method+":"+p.Name, bytes, params.QuotaTokenBytes)
+	if err != nil || ok {
+		return ok, 0, err
+	}
+
+	// Quotas are per UTC day
+	now := time.Now().UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return false, midnight.Sub(now), nil
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package limiter
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"seif/auth"
+	"seif/db_ops"
+	"seif/params"
+	"seif/proxy"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Opens a fresh db, and authenticates the tokens ci, ops and dev. The
+// client IP is taken from X-Forwarded-For, as the test requests come from
+// 0.0.0.0.
+func setup(t *testing.T) {
+	t.Helper()
+	params.DbPath = filepath.Join(t.TempDir(), "seif.db")
+	db_ops.Open()
+	t.Cleanup(func() { params.Db.Close() })
+
+	params.AuthTokensFile = filepath.Join(t.TempDir(), "tokens")
+	content := ""
+	for _, name := range []string{"ci", "ops", "dev"} {
+		content += name + ":" + auth.HashToken("seif_"+name) + "\n"
+	}
+	if err := os.WriteFile(params.AuthTokensFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auth.Init()
+
+	params.TrustedProxies = []string{"0.0.0.0"}
+	params.ClientIpHeader = fiber.HeaderXForwardedFor
+	if err := proxy.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		params.AuthTokensFile, params.TrustedProxies, params.ClientIpHeader = "", nil, ""
+		rates = map[string]Rate{}
+	})
+}
+
+func request(t *testing.T, app *fiber.App, ip, token string) (int, int) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set(fiber.HeaderXForwardedFor, ip)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer seif_"+token)
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retryAfter, _ := strconv.Atoi(res.Header.Get(fiber.HeaderRetryAfter))
+	return res.StatusCode, retryAfter
+}
+
+func TestRateLimits(t *testing.T) {
+	setup(t)
+	rates["create:ip"] = Rate{count: 3, period: time.Hour}
+	rates["create:principal"] = Rate{count: 2, period: time.Hour}
+
+	app := fiber.New()
+	app.Post("/", ByIp("create"), auth.Required, ByPrincipal("create"), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for i, tc := range []struct {
+		ip, token  string
+		status     int
+		retryAfter int // at most, in seconds
+	}{
+		{"1.1.1.1", "ci", fiber.StatusOK, 0},
+		{"1.1.1.1", "ci", fiber.StatusOK, 0},
+		{"2.2.2.2", "ci", fiber.StatusTooManyRequests, 30*60 + 1},  // the token is exhausted, from any IP
+		{"2.2.2.2", "ops", fiber.StatusOK, 0},                      // but not the other tokens
+		{"1.1.1.1", "ops", fiber.StatusOK, 0},                      // the IP has one request left
+		{"1.1.1.1", "dev", fiber.StatusTooManyRequests, 20*60 + 1}, // then it is exhausted, for any token
+		{"3.3.3.3", "dev", fiber.StatusOK, 0},
+	} {
+		status, retryAfter := request(t, app, tc.ip, tc.token)
+		if status != tc.status {
+			t.Errorf("%d: %s %s got %d, expected %d", i, tc.ip, tc.token, status, tc.status)
+		}
+		if (tc.retryAfter == 0) != (retryAfter == 0) || retryAfter > tc.retryAfter {
+			t.Errorf("%d: retry after %d, expected at most %d", i, retryAfter, tc.retryAfter)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	setup(t)
+	params.QuotaTokenBytes = 100
+	t.Cleanup(func() { params.QuotaTokenBytes = 0 })
+
+	// As secrets.Create does
+	app := fiber.New()
+	app.Post("/", auth.Required, func(c *fiber.Ctx) error {
+		params.Lock.Lock()
+		defer params.Lock.Unlock()
+		tx, err := params.Db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		ok, retryAfter, err := CheckQuota(c, tx, 60)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(retryAfter.Seconds())+1))
+			return c.SendStatus(fiber.StatusTooManyRequests)
+		}
+		return tx.Commit()
+	})
+
+	for i, tc := range []struct {
+		token  string
+		status int
+	}{
+		{"ci", fiber.StatusOK},
+		{"ci", fiber.StatusTooManyRequests},
+		{"ops", fiber.StatusOK},
+		{"ci", fiber.StatusTooManyRequests},
+	} {
+		status, retryAfter := request(t, app, "1.1.1.1", tc.token)
+		if status != tc.status {
+			t.Errorf("%d: %s got %d, expected %d", i, tc.token, status, tc.status)
+		}
+		// Until the next UTC midnight
+		if status != fiber.StatusOK && (retryAfter < 1 || retryAfter > 24*60*60+1) {
+			t.Errorf("%d: retry after %d", i, retryAfter)
+		}
+	}
+
+	params.QuotaTokenBytes = 0
+	if status, _ := request(t, app, "1.1.1.1", "ci"); status != fiber.StatusOK {
+		t.Errorf("no quota: got %d", status)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for spec, expected := range map[string]*Rate{
+		"":       nil,
+		"10/m":   {count: 10, period: time.Minute},
+		"10/1m":  {count: 10, period: time.Minute},
+		"100/h":  {count: 100, period: time.Hour},
+		"1000/d": {count: 1000, period: 24 * time.Hour},
+		"5/30s":  {count: 5, period: 30 * time.Second},
+	} {
+		r, err := ParseRate(spec)
+		if err != nil || (r == nil) != (expected == nil) || (r != nil && *r != *expected) {
+			t.Errorf("%q: got %v, %v", spec, r, err)
+		}
+	}
+	for _, spec := range []string{"10", "0/m", "-1/m", "x/m", "10/x", "10/0s", "10/-1m"} {
+		if _, err := ParseRate(spec); err == nil {
+			t.Errorf("%q: accepted", spec)
+		}
+	}
+}
+
+// Each route has its own bucket, e.g. rendering QR codes doesn't use up
+// the status checks
+func TestInitBuckets(t *testing.T) {
+	setup(t)
+	params.LimitStatus, params.LimitQr = "1/h", "2/h"
+	t.Cleanup(func() { params.LimitStatus, params.LimitQr = "", "" })
+	Init()
+
+	app := fiber.New()
+	for _, route := range []string{"status", "qr"} {
+		app.Post("/"+route, ByIp(route), func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusOK)
+		})
+	}
+
+	for i, tc := range []struct {
+		route  string
+		status int
+	}{
+		{"status", fiber.StatusOK},
+		{"status", fiber.StatusTooManyRequests},
+		{"qr", fiber.StatusOK},
+		{"qr", fiber.StatusOK},
+		{"qr", fiber.StatusTooManyRequests},
+	} {
+		req := httptest.NewRequest("POST", "/"+tc.route, nil)
+		req.Header.Set(fiber.HeaderXForwardedFor, "1.1.1.1")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.status {
+			t.Errorf("%d: %s got %d, expected %d", i, tc.route, res.StatusCode, tc.status)
+		}
+	}
+}
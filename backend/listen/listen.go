@@ -0,0 +1,180 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package listen
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"seif/params"
+	"seif/proxy"
+	"strconv"
+	"strings"
+)
+
+// The port of the first TCP listener, see Port
+var tcpPort int
+
+// The port actually listened on, from -listen or systemd too: the port of
+// the first TCP listener, 0 if there's none (e.g. only unix sockets). Valid
+// after Listen.
+func Port() int {
+	return tcpPort
+}
+
+func setPort(ln net.Listener) {
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok && tcpPort == 0 {
+		tcpPort = addr.Port
+	}
+}
+
+// Opens the listeners: the sockets passed by systemd if activated by it,
+// else the ones in -listen, else all interfaces on -port. More listeners
+// are merged in one.
+func Listen() (net.Listener, error) {
+	lns, err := systemdListeners()
+	if err != nil {
+		return nil, fmt.Errorf("in using the systemd sockets: %w", err)
+	}
+	if len(lns) > 0 {
+		for i, ln := range lns {
+			slog.Info("listening on a socket from systemd", "address", ln.Addr().String())
+			setPort(ln)
+			lns[i] = proxy.WrapListener(ln)
+		}
+		return merge(lns), nil
+	}
+
+	addrs := params.Listen
+	if len(addrs) == 0 {
+		addrs = []string{fmt.Sprintf(":%d", params.Port)}
+	}
+	for _, addr := range addrs {
+		ln, err := listen(addr)
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, fmt.Errorf("in listening on %s: %w", addr, err)
+		}
+		slog.Info("listening", "address", addr)
+		setPort(ln)
+		lns = append(lns, proxy.WrapListener(ln))
+	}
+	return merge(lns), nil
+}
+
+// "host:port", ":port" or "unix:/path"
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// A socket left over by an unclean exit would make Listen fail
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := setOwnerAndMode(path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// Applies -socket-mode (octal) and -socket-owner ("user", "user:group"
+// or ":group")
+func setOwnerAndMode(path string) error {
+	if params.SocketMode != "" {
+		mode, err := strconv.ParseUint(params.SocketMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid socket mode '%s'", params.SocketMode)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			return err
+		}
+	}
+
+	if params.SocketOwner != "" {
+		uid, gid := -1, -1
+		usr, grp, _ := strings.Cut(params.SocketOwner, ":")
+		if usr != "" {
+			u, err := user.Lookup(usr)
+			if err != nil {
+				return err
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return err
+			}
+		}
+		if grp != "" {
+			g, err := user.LookupGroup(grp)
+			if err != nil {
+				return err
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return err
+			}
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The sockets passed via socket activation, see sd_listen_fds(3)
+func systemdListeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Not to be inherited by children
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	const firstFd = 3
+	var lns []net.Listener
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("LISTEN_FD_%d", firstFd+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(firstFd+i), name)
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener dups it
+		if err != nil {
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
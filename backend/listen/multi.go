@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package listen
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+type accepted struct {
+	conn net.Conn
+	err  error
+}
+
+// Accepts connections from several listeners, to serve them all with one
+// server
+type multiListener struct {
+	lns      []net.Listener
+	accepted chan accepted
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func merge(lns []net.Listener) net.Listener {
+	if len(lns) == 1 {
+		return lns[0]
+	}
+
+	m := &multiListener{lns: lns, accepted: make(chan accepted), closed: make(chan struct{})}
+	for _, ln := range lns {
+		go m.serve(ln)
+	}
+	return m
+}
+
+// Accepts from one listener until it's closed. Other errors, e.g. too many
+// open files, are passed on to the server and retried with a backoff, as
+// net/http does.
+func (m *multiListener) serve(ln net.Listener) {
+	var backoff time.Duration
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		select {
+		case m.accepted <- accepted{conn, err}:
+		case <-m.closed:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err == nil {
+			backoff = 0
+			continue
+		}
+
+		backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+		select {
+		case <-time.After(backoff):
+		case <-m.closed:
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case a := <-m.accepted:
+		return a.conn, a.err
+	case <-m.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multiListener) Close() error {
+	var err error
+	m.once.Do(func() {
+		close(m.closed)
+		for _, ln := range m.lns {
+			if e := ln.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+// The address of the first listener
+func (m *multiListener) Addr() net.Addr {
+	return m.lns[0].Addr()
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"seif/proxy"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const REDACTED = "[redacted]"
+
+// Attributes and query parameters that can identify or open a secret; t and
+// s are the ID and key in the links for the UI
+var sensitive = map[string]bool{"id": true, "key": true, "t": true, "s": true}
+
+const ctxRequestId = "request_id"
+
+// Sets the default logger, that writes to stderr as "text" or "json"
+func Init(format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level '%s'", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: redactAttr}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid log format '%s'", format)
+	}
+
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if sensitive[a.Key] {
+		return slog.String(a.Key, REDACTED)
+	}
+	return a
+}
+
+// Redacts the sensitive parameters in a query string
+func RedactQuery(query string) string {
+	if query == "" {
+		return ""
+	}
+	var parts []string
+	for _, part := range strings.Split(query, "&") {
+		name, _, _ := strings.Cut(part, "=")
+		if n, err := url.QueryUnescape(name); err == nil && sensitive[n] {
+			part = name + "=" + REDACTED
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "&")
+}
+
+// The logger for a request, with its ID
+func FromCtx(c *fiber.Ctx) *slog.Logger {
+	if id, ok := c.Locals(ctxRequestId).(string); ok {
+		return slog.With(ctxRequestId, id)
+	}
+	return slog.Default()
+}
+
+// Assigns an ID to each request, returned in X-Request-ID, and logs the
+// request when done. The path isn't logged, as it can contain a secret's ID:
+// only the route and the redacted query.
+func Middleware(c *fiber.Ctx) error {
+	start := time.Now()
+	id := newRequestId()
+	c.Locals(ctxRequestId, id)
+	c.Set(fiber.HeaderXRequestID, id)
+	method := c.Method()
+	query := RedactQuery(string(c.Request().URI().QueryString()))
+
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		// Not yet handled by the error handler
+		status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+	}
+
+	FromCtx(c).Info("request",
+		"method", method,
+		"route", c.Route().Path,
+		"query", query,
+		"status", status,
+		"latency_ms", time.Since(start).Milliseconds(),
+		"ip", proxy.ClientIP(c))
+	return err
+}
+
+func newRequestId() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
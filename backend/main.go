@@ -19,82 +19,287 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"crypto/tls"
 	"embed"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"seif/auth"
+	"seif/bots"
+	"seif/certs"
+	"seif/cli"
 	"seif/db_ops"
 	"seif/flags"
+	"seif/handlers/admin"
+	"seif/handlers/api_v2"
 	"seif/handlers/get_init_data"
+	"seif/handlers/get_reveal_nonce"
 	"seif/handlers/get_secret"
 	"seif/handlers/get_secret_status"
+	"seif/handlers/health"
+	"seif/handlers/pages"
 	"seif/handlers/put_secret"
+	"seif/limiter"
+	"seif/listen"
+	"seif/logging"
+	"seif/metrics"
+	"seif/overlay"
 	"seif/params"
+	"seif/proxy"
+	"seif/secheaders"
+	"seif/theme"
 	"seif/utils"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
 	"github.com/gofiber/fiber/v2/middleware/recover"
-	_ "modernc.org/sqlite"
 )
 
 //go:embed static/*
 var static embed.FS
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "token":
+			cli.Token(os.Args[2:])
+			return
+		case "admin":
+			cli.Admin(os.Args[2:])
+			return
+		case "audit":
+			cli.Audit(os.Args[2:])
+			return
+		case "config":
+			cli.Config(os.Args[2:])
+			return
+		case "send":
+			cli.Send(os.Args[2:])
+			return
+		case "open":
+			cli.Open(os.Args[2:])
+			return
+		}
+	}
+
+	params.PrintBanner()
+
 	flags.Parse()
 
-	dbIsNew := !utils.FileExists(params.DbPath)
+	// Logging
 
-	// FIXME don't open a new connection for each operation
-	var err error
-	params.Db, err = sql.Open("sqlite", params.DbPath)
-	if err != nil {
-		panic(err)
+	if err := logging.Init(params.LogFormat, params.LogLevel); err != nil {
+		utils.Abort("%s", err)
 	}
-	defer params.Db.Close()
 
-	// Populates db
-	if dbIsNew {
-		db_ops.InitDb()
-	} else {
-		// check db version
+	// Metrics
 
-		row := params.Db.QueryRow("SELECT VERSION FROM VERSION")
-		var dbVersion int
-		if err := row.Scan(&dbVersion); err != nil {
-			panic(err)
-		}
-		if dbVersion != db_ops.DB_VERSION {
-			utils.Abort("DB version is %d but should be %d. Please upgrade the database or the application.", dbVersion, db_ops.DB_VERSION)
-		}
+	params.Lock.OnWait = func(d time.Duration) { metrics.LockWait.Observe(d.Seconds()) }
+	metrics.NewGaugeFunc("seif_secrets_active", "Secrets that can still be revealed.", db_ops.CountActive)
+	metrics.NewGaugeFunc("seif_db_size_bytes", "Size of the db file.", db_ops.DbSize)
 
+	dbVersion, dbIsNew := db_ops.Open()
+
+	if !dbIsNew {
 		// Backup
 
 		db_ops.Backup()
+
+		// Upgrade, if needed
+
+		if dbVersion < db_ops.DB_VERSION {
+			slog.Info("upgrading db", "from", dbVersion, "to", db_ops.DB_VERSION)
+			db_ops.UpgradeDb(dbVersion)
+		}
+	}
+
+	// Audit log
+
+	if err := db_ops.InitAudit(); err != nil {
+		utils.Abort("in setting up the audit log: %s", err)
 	}
 
 	// Maintenance
 
-	go db_ops.StartMaint()
+	maintCtx, stopMaint := context.WithCancel(context.Background())
+	maintDone := make(chan struct{})
+	go func() {
+		defer close(maintDone)
+		db_ops.StartMaint(maintCtx)
+	}()
+
+	// Authentication
+
+	auth.Init()
+
+	// Rate limits
+
+	limiter.Init()
+
+	// Link previews
+
+	bots.Init()
+
+	// Reverse proxies
+
+	if err := proxy.Init(); err != nil {
+		utils.Abort("%s", err)
+	}
+
+	// TLS
+
+	certs.Init()
+
+	// Theming
+
+	staticFs, err := fs.Sub(static, "static")
+	if err != nil {
+		utils.Abort("in loading the UI: %s", err)
+	}
+	if params.StaticOverlay != "" {
+		staticFs = overlay.New(params.StaticOverlay, staticFs)
+	}
+	if err := theme.Init(); err != nil {
+		utils.Abort("%s", err)
+	}
+
+	// Security headers
+
+	if err := secheaders.Init(staticFs); err != nil {
+		utils.Abort("%s", err)
+	}
 
 	// server
 
-	app := fiber.New(fiber.Config{ServerHeader: "seif v." + params.VERSION, AppName: "seif", DisableStartupMessage: true})
+	appConfig := fiber.Config{ServerHeader: "seif v." + params.VERSION, AppName: "seif", DisableStartupMessage: true}
+	proxy.Configure(&appConfig)
+	app := fiber.New(appConfig)
 
+	app.Use(logging.Middleware)
+	app.Use(metrics.Middleware)
 	app.Use(recover.New())
+	app.Use(secheaders.Middleware)
 
 	app.Use("/", filesystem.New(filesystem.Config{
-		Root:       http.FS(static),
-		PathPrefix: "static",
+		Root: http.FS(staticFs),
 	}))
 
+	app.Get("/theme.css", theme.Css)
 	app.Get("/api/getInitData", get_init_data.GetInitData)
-	app.Delete("/api/getSecret", get_secret.GetSecret)
-	app.Get("/api/getSecretStatus", get_secret_status.GetSecretStatus)
-	app.Put("/api/putSecret", put_secret.PutSecret)
+	app.Get("/api/getRevealNonce", bots.Block, get_reveal_nonce.GetRevealNonce)
+	app.Delete("/api/getSecret", bots.Block, limiter.ByIp("reveal"), get_secret.GetSecret)
+	app.Get("/api/getSecretStatus", bots.Block, limiter.ByIp("status"), get_secret_status.GetSecretStatus)
+	app.Put("/api/putSecret", limiter.ByIp("create"), auth.Required, limiter.ByPrincipal("create"), put_secret.PutSecret)
+
+	v2 := app.Group("/api/v2")
+	v2.Get("/openapi.json", api_v2.OpenApi)
+	v2.Get("/capabilities", get_init_data.GetInitData)
+	v2.Post("/secrets", limiter.ByIp("create"), auth.Required, limiter.ByPrincipal("create"), api_v2.CreateSecret)
+	v2.Get("/secrets/:id", bots.Block, limiter.ByIp("status"), api_v2.GetSecret)
+	v2.Post("/secrets/:id/reveal-nonce", bots.Block, api_v2.CreateRevealNonce)
+	v2.Post("/secrets/:id/reveal", bots.Block, limiter.ByIp("reveal"), api_v2.RevealSecret)
+	v2.Post("/qr", limiter.ByIp("qr"), auth.Required, api_v2.QrCode)
+
+	app.Get("/nojs/", auth.Optional, pages.Create)
+	app.Post("/nojs/create", limiter.ByIp("create"), auth.Required, limiter.ByPrincipal("create"), pages.PostCreate)
+	app.Get("/nojs/reveal", bots.Block, limiter.ByIp("status"), pages.Reveal)
+	app.Post("/nojs/reveal", bots.Block, limiter.ByIp("reveal"), pages.PostReveal)
+
+	app.Get("/healthz", health.Healthz)
+	app.Get("/readyz", health.Readyz)
+
+	// On the main port, the metrics are for admins only, as they tell the
+	// traffic and what is being limited or blocked
+	var metricsApp *fiber.App
+	if params.MetricsListen == "" {
+		if auth.Enabled() {
+			app.Get("/metrics", certs.RequireClientCert, auth.Required, auth.Admin, metrics.Handler)
+		}
+	} else {
+		metricsApp = fiber.New(fiber.Config{ServerHeader: "seif v." + params.VERSION, AppName: "seif", DisableStartupMessage: true})
+		metricsApp.Get("/metrics", metrics.Handler)
+		metricsApp.Get("/readyz", health.ReadyzDetailed)
+		go func() {
+			if err := metricsApp.Listen(params.MetricsListen); err != nil {
+				utils.Abort("in serving metrics: %s", err)
+			}
+		}()
+		slog.Info("serving metrics", "address", params.MetricsListen)
+	}
+
+	if auth.Enabled() {
+		adminApi := app.Group("/api/admin", certs.RequireClientCert, auth.Required, auth.Admin)
+		adminApi.Get("/stats", admin.Stats)
+		adminApi.Get("/secrets", admin.ListSecrets)
+		adminApi.Delete("/secrets", admin.PurgeSecrets)
+		adminApi.Delete("/secrets/:id", admin.PurgeSecret)
+		adminApi.Post("/maint", admin.Maint)
+		adminApi.Post("/backup", admin.Backup)
+	}
 
-	fmt.Println("  - server on port", params.Port)
-	fmt.Printf("  - all ok. Please open http://localhost:%d\n", params.Port)
-	app.Listen(fmt.Sprintf(":%d", params.Port))
+	if params.OidcIssuer != "" {
+		app.Get("/auth/login", auth.Login)
+		app.Get("/auth/callback", auth.Callback)
+		app.Post("/auth/logout", auth.Logout)
+	}
+
+	ln, err := listen.Listen()
+	if err != nil {
+		utils.Abort("%s", err)
+	}
+	scheme := "http"
+	if certs.Enabled() {
+		ln = tls.NewListener(ln, certs.Config())
+		scheme = "https"
+		certs.StartRedirect(listen.Port())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	served := make(chan error, 1)
+	go func() { served <- app.Listener(ln) }()
+
+	if port := listen.Port(); port != 0 {
+		slog.Info("server started", "url", fmt.Sprintf("%s://localhost:%d", scheme, port))
+	} else {
+		slog.Info("server started", "scheme", scheme)
+	}
+	select {
+	case err := <-served:
+		utils.Abort("in serving: %s", err)
+	case <-ctx.Done():
+	}
+
+	// Graceful shutdown: stop accepting connections, wait for the requests
+	// in progress on all the servers and for the maintenance, then close
+	// the db
+
+	slog.Info("shutting down", "timeout", params.ShutdownTimeout)
+	if err := app.ShutdownWithTimeout(params.ShutdownTimeout); err != nil {
+		slog.Warn("not all requests completed", "error", err)
+	}
+	if metricsApp != nil {
+		if err := metricsApp.ShutdownWithTimeout(params.ShutdownTimeout); err != nil {
+			slog.Warn("not all metrics requests completed", "error", err)
+		}
+	}
+	if err := certs.StopRedirect(params.ShutdownTimeout); err != nil {
+		slog.Warn("not all HTTP requests completed", "error", err)
+	}
+	stopMaint()
+	<-maintDone
+
+	if err := db_ops.Close(); err != nil {
+		slog.Error("in closing the db", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("shutdown complete")
 }
@@ -0,0 +1,237 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A minimal implementation of the Prometheus text exposition format,
+// covering what seif needs: counters and histograms with labels, and gauges
+// computed at scrape time.
+
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var registryMu sync.Mutex
+var registry []metric
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// HELP texts don't escape quotes
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func labelsString(names, values []string, extra ...string) string {
+	var parts []string
+	for i, n := range names {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, n, labelEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, extra[i], labelEscaper.Replace(extra[i+1])))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(f)
+}
+
+type header struct {
+	name, help, typ string
+	labels          []string
+}
+
+func (h *header) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", h.name, helpEscaper.Replace(h.help), h.name, h.typ)
+}
+
+// Counter
+
+type CounterVec struct {
+	header
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{header: header{name, help, "counter", labels}, values: map[string]float64{}, keys: map[string][]string{}}
+	if len(labels) == 0 {
+		// So that it's exported as 0 before the first increment
+		c.values[""] = 0
+		c.keys[""] = nil
+	}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	k := strings.Join(labelValues, "\x00")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.keys[k]; !ok {
+		c.keys[k] = cloneAll(labelValues)
+	}
+	c.values[k] += v
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelsString(c.labels, c.keys[k]), formatFloat(c.values[k]))
+	}
+}
+
+// Histogram
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+type HistogramVec struct {
+	header
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram
+	keys    map[string][]string
+}
+
+func NewHistogramVec(name, help string, labels ...string) *HistogramVec {
+	h := &HistogramVec{header: header{name, help, "histogram", labels}, buckets: defaultBuckets, values: map[string]*histogram{}, keys: map[string][]string{}}
+	if len(labels) == 0 {
+		h.values[""] = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.keys[""] = nil
+	}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	k := strings.Join(labelValues, "\x00")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.values[k]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hist
+		h.keys[k] = cloneAll(labelValues)
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hist.counts[i]++
+			break
+		}
+	}
+	hist.sum += v
+	hist.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, k := range sortedKeys(h.values) {
+		hist := h.values[k]
+		var cumulative uint64
+		for i, b := range h.buckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelsString(h.labels, h.keys[k], "le", formatFloat(b)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelsString(h.labels, h.keys[k], "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelsString(h.labels, h.keys[k]), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelsString(h.labels, h.keys[k]), hist.count)
+	}
+}
+
+// Gauge, computed when scraped. If the function fails, the gauge is omitted.
+
+type GaugeFunc struct {
+	header
+	f func() (float64, error)
+}
+
+func NewGaugeFunc(name, help string, f func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{header: header{name, help, "gauge", nil}, f: f}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	v, err := g.f()
+	if err != nil {
+		return
+	}
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(v))
+}
+
+// Label values can come from fasthttp buffers, that are reused
+func cloneAll(ss []string) []string {
+	ret := make([]string, len(ss))
+	for i, s := range ss {
+		ret[i] = strings.Clone(s)
+	}
+	return ret
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Writes all the metrics, in the text exposition format
+func WriteAll(w io.Writer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, m := range registry {
+		m.write(w)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func written(m metric) string {
+	var buf bytes.Buffer
+	m.write(&buf)
+	return buf.String()
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounterVec("test_requests_total", `Requests, by "route" \ path`+"\nand status", "route", "status")
+	c.Inc("/b", "200")
+	c.Inc("/a", "404")
+	c.Add(2.5, "/a", "404")
+	c.Inc(`/q"uote\back`+"\nslash", "500")
+
+	expected := `# HELP test_requests_total Requests, by "route" \\ path\nand status
+# TYPE test_requests_total counter
+test_requests_total{route="/a",status="404"} 3.5
+test_requests_total{route="/b",status="200"} 1
+test_requests_total{route="/q\"uote\\back\nslash",status="500"} 1
+`
+	if got := written(c); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	// Without labels, it's there before the first increment
+	expected = `# HELP test_total Total.
+# TYPE test_total counter
+test_total 0
+`
+	if got := written(NewCounterVec("test_total", "Total.")); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogramVec("test_seconds", "Durations.", "op")
+	h.buckets = []float64{0.1, 1, 10}
+	for _, v := range []float64{0.05, 0.1, 0.5, 20} {
+		h.Observe(v, "get")
+	}
+
+	expected := `# HELP test_seconds Durations.
+# TYPE test_seconds histogram
+test_seconds_bucket{op="get",le="0.1"} 2
+test_seconds_bucket{op="get",le="1"} 3
+test_seconds_bucket{op="get",le="10"} 3
+test_seconds_bucket{op="get",le="+Inf"} 4
+test_seconds_sum{op="get"} 20.65
+test_seconds_count{op="get"} 4
+`
+	if got := written(h); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	// Without labels, it's there before the first observation
+	expected = "test_empty_seconds_bucket{le=\"+Inf\"} 0\ntest_empty_seconds_sum 0\ntest_empty_seconds_count 0\n"
+	if got := written(NewHistogramVec("test_empty_seconds", "Durations.")); !strings.HasSuffix(got, expected) {
+		t.Errorf("got:\n%s\nexpected it to end with:\n%s", got, expected)
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	expected := "# HELP test_size_bytes Size.\n# TYPE test_size_bytes gauge\ntest_size_bytes 1.5e+06\n"
+	if got := written(NewGaugeFunc("test_size_bytes", "Size.", func() (float64, error) { return 1.5e6, nil })); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	// Omitted altogether if it can't be computed
+	if got := written(NewGaugeFunc("test_failing", "Fails.", func() (float64, error) { return 0, errors.New("failed") })); got != "" {
+		t.Errorf("got:\n%s", got)
+	}
+}
+
+// Every metric is written once, with its header before its samples
+func TestWriteAll(t *testing.T) {
+	var buf bytes.Buffer
+	WriteAll(&buf)
+	seen := map[string]bool{}
+	var current string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if name, ok := strings.CutPrefix(line, "# HELP "); ok {
+			current, _, _ = strings.Cut(name, " ")
+			if seen[current] {
+				t.Errorf("%s: written twice", current)
+			}
+			seen[current] = true
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		if current == "" || !strings.HasPrefix(line, current) {
+			t.Errorf("%q: not after its header", line)
+		}
+	}
+	if len(seen) == 0 {
+		t.Error("no metrics")
+	}
+}
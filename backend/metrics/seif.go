@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package metrics
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var RequestsTotal = NewCounterVec("seif_http_requests_total", "HTTP requests, by route and status.", "method", "route", "status")
+var RequestDuration = NewHistogramVec("seif_http_request_duration_seconds", "HTTP request latency, by route.", "method", "route")
+
+var SecretsCreated = NewCounterVec("seif_secrets_created_total", "Secrets created.")
+var SecretsRevealed = NewCounterVec("seif_secrets_revealed_total", "Secrets revealed.")
+var SecretsExpired = NewCounterVec("seif_secrets_expired_total", "Secrets deleted by maintenance because expired.")
+var DecryptFailures = NewCounterVec("seif_decrypt_failures_total", "Failed attempts to decrypt a secret, e.g. wrong key.")
+
+var BackupDuration = NewHistogramVec("seif_backup_duration_seconds", "Duration of the backups.")
+var BackupFailures = NewCounterVec("seif_backup_failures_total", "Failed backups.")
+var MaintDuration = NewHistogramVec("seif_maint_duration_seconds", "Duration of the maintenance runs, cleanup and VACUUM.")
+var MaintFailures = NewCounterVec("seif_maint_failures_total", "Failed maintenance runs.")
+
+var LockWait = NewHistogramVec("seif_lock_wait_seconds", "Time spent waiting for the db lock.")
+
+// Middleware that measures requests
+func Middleware(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	// The route is known only after routing
+	route := c.Route().Path
+	status := c.Response().StatusCode()
+	if err != nil {
+		// Not yet handled by the error handler
+		status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+	}
+
+	RequestsTotal.Inc(c.Method(), route, strconv.Itoa(status))
+	RequestDuration.Observe(time.Since(start).Seconds(), c.Method(), route)
+	return err
+}
+
+func Handler(c *fiber.Ctx) error {
+	var buf bytes.Buffer
+	WriteAll(&buf)
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
+}
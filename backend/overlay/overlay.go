@@ -0,0 +1,50 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package overlay
+
+import (
+	"io/fs"
+	"os"
+)
+
+// A file system where the files in a directory shadow the ones in base, e.g.
+// to rebrand the embedded UI. Directories always come from base, if there.
+type overlayFS struct {
+	dir  fs.FS
+	base fs.FS
+}
+
+func New(dir string, base fs.FS) fs.FS {
+	return &overlayFS{dir: os.DirFS(dir), base: base}
+}
+
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.dir.Open(name)
+	if err != nil {
+		return o.base.Open(name)
+	}
+	if st, err := f.Stat(); err == nil && !st.IsDir() {
+		return f, nil
+	}
+	if bf, err := o.base.Open(name); err == nil {
+		f.Close()
+		return bf, nil
+	}
+	return f, nil
+}
@@ -22,6 +22,7 @@ import (
 	"database/sql"
 	"fmt"
 	"sync"
+	"time"
 )
 
 const VERSION = "v0.0.0"
@@ -32,11 +33,25 @@ const banner = `   ____    _ ___
  _\ \/ -_) / _/ 
 /___/\__/_/_/`
 
-var Lock sync.Mutex
+// A mutex that reports how long each Lock() waited, e.g. for metrics
+type timedMutex struct {
+	sync.Mutex
+	OnWait func(time.Duration)
+}
+
+func (m *timedMutex) Lock() {
+	start := time.Now()
+	m.Mutex.Lock()
+	if m.OnWait != nil {
+		m.OnWait(time.Since(start))
+	}
+}
+
+var Lock timedMutex
 
 var Db *sql.DB
 
-func init() {
+func PrintBanner() {
 	fmt.Println(banner, VERSION)
 	fmt.Println()
 }
@@ -18,8 +18,68 @@
  */
 package params
 
+import "time"
+
 var DbPath string
 var Port int
 var MaxDays int
 var DefaultDays int
 var MaxBytes int
+var MaxDelayDays int
+var ExpiryPresets []int
+
+var AuthTokensFile string
+var AuthDbTokens bool
+var AuthHtpasswd string
+var OidcIssuer string
+var OidcClientId string
+var OidcClientSecret string
+var OidcRedirectUrl string
+
+var LimitCreate string
+var LimitReveal string
+var LimitStatus string
+var LimitQr string
+var LimitTokenCreate string
+var QuotaTokenBytes int
+
+var AdminUsers []string
+
+var AuditRetentionDays int
+
+var Listen []string
+var SocketMode string
+var SocketOwner string
+
+var TrustedProxies []string
+var ClientIpHeader string
+var ProxyProtocol bool
+
+var Csp string
+var ReferrerPolicy string
+var FrameOptions string
+var PermissionsPolicy string
+var HstsMaxAge int
+var ApiCacheControl string
+
+var StaticOverlay string
+var ThemeFile string
+
+var MetricsListen string
+
+var TlsCert string
+var TlsKey string
+var TlsClientCa string
+var HttpRedirectPort int
+
+var AcmeDomains []string
+var AcmeDirectory string
+var AcmeEmail string
+var AcmeCaRoot string
+
+var ShutdownTimeout time.Duration
+
+var LogFormat string
+var LogLevel string
+
+var ReadyMinFreeMb int
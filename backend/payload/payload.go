@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const TypeText = "text"
+const TypeCredentials = "credentials"
+const TypeTOTP = "totp"
+const TypeEnv = "env"
+
+// All the types above, as advertised to clients
+var Types = []string{TypeText, TypeCredentials, TypeTOTP, TypeEnv}
+
+// How the plaintext is laid out before encryption, saved in SECRETS.FORMAT
+const FormatRaw = 0      // free text, as in the first versions
+const FormatEnvelope = 1 // JSON envelope, see below
+
+// The envelope is what gets encrypted, as a single unit, for typed secrets
+type envelope struct {
+	Type   string          `json:"t"`
+	Fields json.RawMessage `json:"f"`
+}
+
+type typed interface {
+	validate() (field string, err error)
+	render() string
+}
+
+func newTyped(typ string) typed {
+	switch typ {
+	case TypeCredentials:
+		return new(Credentials)
+	case TypeTOTP:
+		return new(TOTP)
+	case TypeEnv:
+		return new(Env)
+	}
+	return nil
+}
+
+// A secret, as the handlers see it. For TypeText, Fields is nil.
+type Secret struct {
+	Type   string
+	Text   string
+	Fields json.RawMessage
+}
+
+// Error returned by Encode when the request is invalid; Field is what
+// to report to the client as the malformed object.
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Err.Error())
+}
+
+func invalid(field string, err error) *ValidationError {
+	return &ValidationError{Field: field, Err: err}
+}
+
+// Validates and encodes a secret for encryption. Returns the plaintext to
+// encrypt and its format.
+func Encode(s Secret) (plain string, format int, err error) {
+	// "fields": null is the same as no fields at all
+	if bytes.Equal(bytes.TrimSpace(s.Fields), []byte("null")) {
+		s.Fields = nil
+	}
+	if s.Type == "" || s.Type == TypeText {
+		if s.Fields != nil {
+			return "", 0, invalid("fields", errors.New("not allowed for text secrets"))
+		}
+		return s.Text, FormatRaw, nil
+	}
+
+	t := newTyped(s.Type)
+	if t == nil {
+		return "", 0, invalid("type", fmt.Errorf("unknown secret type '%s'", s.Type))
+	}
+	if s.Text != "" {
+		return "", 0, invalid("secret", errors.New("not allowed for typed secrets, use fields"))
+	}
+	if s.Fields == nil {
+		return "", 0, invalid("fields", errors.New("is missing"))
+	}
+	if err := json.Unmarshal(s.Fields, t); err != nil {
+		return "", 0, invalid("fields", err)
+	}
+	if field, err := t.validate(); err != nil {
+		return "", 0, invalid(field, err)
+	}
+
+	// Re-marshal the validated struct, so that unknown fields are dropped
+	fields, err := json.Marshal(t)
+	if err != nil {
+		return "", 0, err
+	}
+	env, err := json.Marshal(envelope{Type: s.Type, Fields: fields})
+	if err != nil {
+		return "", 0, err
+	}
+	return string(env), FormatEnvelope, nil
+}
+
+// Decodes a decrypted plaintext. Text is always filled, for typed secrets
+// with a plain text rendering, for clients that don't know the type.
+func Decode(plain string, format int) (*Secret, error) {
+	if format == FormatRaw {
+		return &Secret{Type: TypeText, Text: plain}, nil
+	}
+	if format != FormatEnvelope {
+		return nil, fmt.Errorf("unknown payload format %d", format)
+	}
+
+	var env envelope
+	if err := json.Unmarshal([]byte(plain), &env); err != nil {
+		return nil, err
+	}
+	t := newTyped(env.Type)
+	if t == nil {
+		return nil, fmt.Errorf("unknown secret type '%s'", env.Type)
+	}
+	if err := json.Unmarshal(env.Fields, t); err != nil {
+		return nil, err
+	}
+	return &Secret{Type: env.Type, Text: t.render(), Fields: env.Fields}, nil
+}
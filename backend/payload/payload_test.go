@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	for _, tc := range []struct {
+		in     Secret
+		format int
+		text   string
+	}{
+		{Secret{Text: "hello"}, FormatRaw, "hello"},
+		{Secret{Type: TypeText, Text: "hello", Fields: json.RawMessage(" null ")}, FormatRaw, "hello"},
+		{Secret{Type: TypeCredentials, Fields: json.RawMessage(`{"username":"bob","password":"pw","extra":1}`)}, FormatEnvelope, "Username: bob\nPassword: pw\n"},
+		{Secret{Type: TypeTOTP, Fields: json.RawMessage(`{"seed":"jbsw y3dp ehpk 3pxp==","issuer":"Acme","account":"bob"}`)}, FormatEnvelope,
+			"otpauth://totp/Acme:bob?algorithm=SHA1&digits=6&issuer=Acme&period=30&secret=JBSWY3DPEHPK3PXP"},
+		{Secret{Type: TypeEnv, Fields: json.RawMessage(`{"vars":[{"name":"A","value":"x"},{"name":"B","value":"a \"b\"\n"}]}`)}, FormatEnvelope, "A=x\nB=\"a \\\"b\\\"\\n\"\n"},
+	} {
+		plain, format, err := Encode(tc.in)
+		if err != nil {
+			t.Errorf("%v: %s", tc.in, err)
+			continue
+		}
+		if format != tc.format {
+			t.Errorf("%v: format %d, expected %d", tc.in, format, tc.format)
+		}
+		s, err := Decode(plain, format)
+		if err != nil {
+			t.Errorf("%v: %s", tc.in, err)
+			continue
+		}
+		if s.Text != tc.text {
+			t.Errorf("%v: text %q, expected %q", tc.in, s.Text, tc.text)
+		}
+		if format == FormatEnvelope && (s.Type != tc.in.Type || strings.Contains(string(s.Fields), "extra")) {
+			t.Errorf("%v: decoded as %s %s", tc.in, s.Type, s.Fields)
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		in    Secret
+		field string
+	}{
+		{Secret{Text: "hello", Fields: json.RawMessage(`{}`)}, "fields"},
+		{Secret{Type: "unknown", Fields: json.RawMessage(`{}`)}, "type"},
+		{Secret{Type: TypeCredentials, Text: "hello", Fields: json.RawMessage(`{"username":"bob"}`)}, "secret"},
+		{Secret{Type: TypeCredentials}, "fields"},
+		{Secret{Type: TypeCredentials, Fields: json.RawMessage("null")}, "fields"},
+		{Secret{Type: TypeCredentials, Fields: json.RawMessage(`[]`)}, "fields"},
+		{Secret{Type: TypeCredentials, Fields: json.RawMessage(`{"notes":"no credentials"}`)}, "fields"},
+		{Secret{Type: TypeCredentials, Fields: json.RawMessage(`{"username":"bob","url":"example.com"}`)}, "url"},
+		{Secret{Type: TypeTOTP, Fields: json.RawMessage(`{"seed":"  "}`)}, "seed"},
+		{Secret{Type: TypeTOTP, Fields: json.RawMessage(`{"seed":"not base32!"}`)}, "seed"},
+		{Secret{Type: TypeTOTP, Fields: json.RawMessage(`{"seed":"JBSWY3DP","algorithm":"md5"}`)}, "algorithm"},
+		{Secret{Type: TypeTOTP, Fields: json.RawMessage(`{"seed":"JBSWY3DP","digits":7}`)}, "digits"},
+		{Secret{Type: TypeTOTP, Fields: json.RawMessage(`{"seed":"JBSWY3DP","period":301}`)}, "period"},
+		{Secret{Type: TypeEnv, Fields: json.RawMessage(`{"vars":[]}`)}, "vars"},
+		{Secret{Type: TypeEnv, Fields: json.RawMessage(`{"vars":[{"name":"1A","value":"x"}]}`)}, "vars"},
+		{Secret{Type: TypeEnv, Fields: json.RawMessage(`{"vars":[{"name":"A"},{"name":"A"}]}`)}, "vars"},
+	} {
+		_, _, err := Encode(tc.in)
+		var verr *ValidationError
+		if !errors.As(err, &verr) || verr.Field != tc.field {
+			t.Errorf("%s %s: got %v, expected an error on %s", tc.in.Type, tc.in.Fields, err, tc.field)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		plain  string
+		format int
+	}{
+		{"hello", 2},
+		{"not json", FormatEnvelope},
+		{`{"t":"unknown","f":{}}`, FormatEnvelope},
+		{`{"t":"credentials","f":[]}`, FormatEnvelope},
+	} {
+		if _, err := Decode(tc.plain, tc.format); err == nil {
+			t.Errorf("%q: decoded", tc.plain)
+		}
+	}
+}
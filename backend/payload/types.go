@@ -0,0 +1,180 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package payload
+
+import (
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Credential set
+
+type Credentials struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Notes    string `json:"notes,omitempty"`
+}
+
+func (c *Credentials) validate() (string, error) {
+	if c.Username == "" && c.Password == "" {
+		return "fields", errors.New("at least one of username and password is needed")
+	}
+	if c.URL != "" {
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return "url", err
+		}
+		if !u.IsAbs() {
+			return "url", errors.New("must be absolute")
+		}
+	}
+	return "", nil
+}
+
+func (c *Credentials) render() string {
+	var sb strings.Builder
+	line := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "%s: %s\n", name, value)
+		}
+	}
+	line("Username", c.Username)
+	line("Password", c.Password)
+	line("URL", c.URL)
+	if c.Notes != "" {
+		fmt.Fprintf(&sb, "\n%s\n", c.Notes)
+	}
+	return sb.String()
+}
+
+// TOTP seed
+
+type TOTP struct {
+	Seed      string `json:"seed"`
+	Issuer    string `json:"issuer,omitempty"`
+	Account   string `json:"account,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Digits    int    `json:"digits,omitempty"`
+	Period    int    `json:"period,omitempty"`
+}
+
+func (t *TOTP) validate() (string, error) {
+	// Seeds are often shown grouped with spaces, in lowercase or padded
+	t.Seed = strings.ToUpper(strings.ReplaceAll(t.Seed, " ", ""))
+	t.Seed = strings.TrimRight(t.Seed, "=")
+	if t.Seed == "" {
+		return "seed", errors.New("is empty")
+	}
+	if _, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(t.Seed); err != nil {
+		return "seed", errors.New("is not valid base32")
+	}
+
+	t.Algorithm = strings.ToUpper(t.Algorithm)
+	switch t.Algorithm {
+	case "":
+		t.Algorithm = "SHA1"
+	case "SHA1", "SHA256", "SHA512":
+	default:
+		return "algorithm", errors.New("must be one of SHA1, SHA256, SHA512")
+	}
+
+	switch t.Digits {
+	case 0:
+		t.Digits = 6
+	case 6, 8:
+	default:
+		return "digits", errors.New("must be 6 or 8")
+	}
+
+	if t.Period == 0 {
+		t.Period = 30
+	} else if t.Period < 1 || t.Period > 300 {
+		return "period", errors.New("must be between 1 and 300 seconds")
+	}
+
+	return "", nil
+}
+
+// Renders as an otpauth:// URI, that can be imported in most authenticator apps
+func (t *TOTP) render() string {
+	label := t.Account
+	if t.Issuer != "" {
+		label = t.Issuer + ":" + t.Account
+	}
+	q := url.Values{}
+	q.Set("secret", t.Seed)
+	if t.Issuer != "" {
+		q.Set("issuer", t.Issuer)
+	}
+	q.Set("algorithm", t.Algorithm)
+	q.Set("digits", fmt.Sprint(t.Digits))
+	q.Set("period", fmt.Sprint(t.Period))
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// Env/dotenv bundle
+
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Env struct {
+	Vars []EnvVar `json:"vars"`
+}
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var envPlainRegexp = regexp.MustCompile(`^[A-Za-z0-9_./:@+,=-]*$`)
+
+func (e *Env) validate() (string, error) {
+	if len(e.Vars) == 0 {
+		return "vars", errors.New("is empty")
+	}
+	seen := make(map[string]bool)
+	for _, v := range e.Vars {
+		if !envNameRegexp.MatchString(v.Name) {
+			return "vars", fmt.Errorf("'%s' is not a valid variable name", v.Name)
+		}
+		if seen[v.Name] {
+			return "vars", fmt.Errorf("'%s' is repeated", v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return "", nil
+}
+
+// Renders as a dotenv file
+func (e *Env) render() string {
+	var sb strings.Builder
+	for _, v := range e.Vars {
+		value := v.Value
+		if !envPlainRegexp.MatchString(value) {
+			value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(value)
+			value = `"` + value + `"`
+		}
+		fmt.Fprintf(&sb, "%s=%s\n", v.Name, value)
+	}
+	return sb.String()
+}
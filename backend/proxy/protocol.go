@@ -0,0 +1,44 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package proxy
+
+import (
+	"net"
+	"seif/params"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// With -proxy-protocol, reads the PROXY protocol header (v1 or v2) sent by
+// trusted proxies, so that the connection's address is the client's
+func WrapListener(ln net.Listener) net.Listener {
+	if !params.ProxyProtocol {
+		return ln
+	}
+	return &proxyproto.Listener{
+		Listener: ln,
+		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
+			if IsTrustedPeer(upstream) {
+				return proxyproto.USE, nil
+			}
+			// Anyone else could claim any address
+			return proxyproto.IGNORE, nil
+		},
+	}
+}
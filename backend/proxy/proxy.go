@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"seif/params"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const HeaderForwarded = "Forwarded"
+const HeaderXRealIP = "X-Real-IP"
+
+var trusted []netip.Prefix
+var trustUnix bool
+
+// Parses -trusted-proxies. Doesn't abort by itself, as utils logs via
+// logging, that uses this package.
+func Init() error {
+	for _, spec := range params.TrustedProxies {
+		spec = strings.TrimSpace(spec)
+		if spec == "unix" {
+			trustUnix = true
+			continue
+		}
+		prefix, err := parsePrefix(spec)
+		if err != nil {
+			return fmt.Errorf("in parsing trusted proxy '%s': %w", spec, err)
+		}
+		trusted = append(trusted, prefix)
+	}
+	return nil
+}
+
+// A CIDR or a single address
+func parsePrefix(spec string) (netip.Prefix, error) {
+	if strings.Contains(spec, "/") {
+		return netip.ParsePrefix(spec)
+	}
+	addr, err := netip.ParseAddr(spec)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a connection comes from a trusted proxy
+func IsTrustedPeer(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case *net.UnixAddr:
+		return trustUnix
+	case *net.TCPAddr:
+		ip, ok := netip.AddrFromSlice(a.IP)
+		return ok && isTrusted(ip)
+	}
+	return false
+}
+
+// Makes fiber honour X-Forwarded-Proto and -Host only from trusted proxies
+func Configure(cfg *fiber.Config) {
+	cfg.EnableTrustedProxyCheck = true
+	for _, spec := range params.TrustedProxies {
+		if spec != "unix" {
+			cfg.TrustedProxies = append(cfg.TrustedProxies, spec)
+		}
+	}
+	if params.ClientIpHeader != HeaderForwarded {
+		cfg.ProxyHeader = params.ClientIpHeader
+	}
+}
+
+// The IP of the client: the peer's, unless it's a trusted proxy and the
+// configured header says otherwise. Use this instead of c.IP().
+func ClientIP(c *fiber.Ctx) string {
+	peer := c.Context().RemoteAddr()
+	ip := c.Context().RemoteIP().String()
+	if params.ClientIpHeader == "" || !IsTrustedPeer(peer) {
+		return ip
+	}
+
+	var hops []string
+	switch params.ClientIpHeader {
+	case HeaderXRealIP:
+		hops = []string{c.Get(HeaderXRealIP)}
+	case fiber.HeaderXForwardedFor:
+		hops = splitList(c.Get(fiber.HeaderXForwardedFor))
+	case HeaderForwarded:
+		hops = forwardedFor(c.Get(HeaderForwarded))
+	}
+
+	// The rightmost address not added by a trusted proxy; the ones to its
+	// left could be forged by the client
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseHop(hops[i])
+		if err != nil {
+			break
+		}
+		ip = addr.String()
+		if !isTrusted(addr) {
+			break
+		}
+	}
+	return ip
+}
+
+func splitList(header string) []string {
+	var ret []string
+	for _, item := range strings.Split(header, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// The for= parameters of a Forwarded header, see RFC 7239
+func forwardedFor(header string) []string {
+	var ret []string
+	for _, elem := range splitList(header) {
+		for _, pair := range strings.Split(elem, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if strings.EqualFold(name, "for") {
+				ret = append(ret, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return ret
+}
+
+// An address, possibly with a port and, for IPv6, in brackets
+func parseHop(hop string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(hop); err == nil {
+		return addr, nil
+	}
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr(), nil
+	}
+	if addr, err := netip.ParseAddr(strings.Trim(hop, "[]")); err == nil {
+		return addr, nil
+	}
+	return netip.Addr{}, fmt.Errorf("invalid address '%s'", hop)
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"seif/params"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func setTrusted(t *testing.T, header string, proxies ...string) {
+	t.Helper()
+	trusted, trustUnix = nil, false
+	params.TrustedProxies, params.ClientIpHeader = proxies, header
+	t.Cleanup(func() {
+		trusted, trustUnix = nil, false
+		params.TrustedProxies, params.ClientIpHeader = nil, ""
+	})
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The test requests come from 0.0.0.0
+func clientIp(t *testing.T, headers map[string]string) string {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(ClientIP(c))
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	return string(body)
+}
+
+func TestClientIP(t *testing.T) {
+	const xff, real, fwd = fiber.HeaderXForwardedFor, HeaderXRealIP, HeaderForwarded
+
+	for i, tc := range []struct {
+		header   string   // -client-ip-header
+		proxies  []string // -trusted-proxies
+		headers  map[string]string
+		expected string
+	}{
+		// No header configured, or an untrusted peer: its own address,
+		// whatever it sends
+		{"", nil, map[string]string{xff: "1.1.1.1"}, "0.0.0.0"},
+		{"", []string{"0.0.0.0"}, map[string]string{xff: "1.1.1.1"}, "0.0.0.0"},
+		{xff, []string{"10.0.0.0/8"}, map[string]string{xff: "1.1.1.1"}, "0.0.0.0"},
+		{real, []string{"10.0.0.0/8", "unix"}, map[string]string{real: "1.1.1.1"}, "0.0.0.0"},
+		{fwd, []string{"10.0.0.0/8"}, map[string]string{fwd: "for=1.1.1.1"}, "0.0.0.0"},
+
+		// Only the configured header counts
+		{xff, []string{"0.0.0.0"}, map[string]string{real: "1.1.1.1", fwd: "for=2.2.2.2"}, "0.0.0.0"},
+		{real, []string{"0.0.0.0"}, map[string]string{xff: "1.1.1.1"}, "0.0.0.0"},
+
+		// The rightmost hop that isn't a trusted proxy; the client can
+		// prepend anything
+		{xff, []string{"0.0.0.0"}, map[string]string{xff: "1.1.1.1"}, "1.1.1.1"},
+		{xff, []string{"0.0.0.0"}, map[string]string{xff: "6.6.6.6, 1.1.1.1"}, "1.1.1.1"},
+		{xff, []string{"0.0.0.0", "10.0.0.0/8"}, map[string]string{xff: "6.6.6.6, 1.1.1.1, 10.0.0.2,10.0.0.1"}, "1.1.1.1"},
+		{xff, []string{"0.0.0.0", "10.0.0.0/8"}, map[string]string{xff: "10.0.0.2, 10.0.0.1"}, "10.0.0.2"},
+		{xff, []string{"0.0.0.0", "10.0.0.0/8"}, map[string]string{xff: "garbage, 10.0.0.1"}, "10.0.0.1"},
+		{xff, []string{"0.0.0.0"}, map[string]string{xff: "1.1.1.1, garbage"}, "0.0.0.0"},
+		{xff, []string{"0.0.0.0"}, map[string]string{xff: "1.1.1.1:1234"}, "1.1.1.1"},
+		{xff, []string{"0.0.0.0"}, map[string]string{xff: "2001:db8::1"}, "2001:db8::1"},
+		{xff, []string{"0.0.0.0"}, map[string]string{}, "0.0.0.0"},
+		{real, []string{"0.0.0.0"}, map[string]string{real: "1.1.1.1"}, "1.1.1.1"},
+		{real, []string{"0.0.0.0"}, map[string]string{real: "6.6.6.6, 1.1.1.1"}, "0.0.0.0"},
+		{fwd, []string{"0.0.0.0"}, map[string]string{fwd: "for=1.1.1.1"}, "1.1.1.1"},
+		{fwd, []string{"0.0.0.0"}, map[string]string{fwd: `for=6.6.6.6, for="[2001:db8::1]:4711";proto=https`}, "2001:db8::1"},
+		{fwd, []string{"0.0.0.0", "10.0.0.1"}, map[string]string{fwd: "for=1.1.1.1;by=x, proto=http;For=10.0.0.1"}, "1.1.1.1"},
+		{fwd, []string{"0.0.0.0"}, map[string]string{fwd: "for=unknown"}, "0.0.0.0"},
+	} {
+		setTrusted(t, tc.header, tc.proxies...)
+		if ip := clientIp(t, tc.headers); ip != tc.expected {
+			t.Errorf("%d: %s %v got %s, expected %s", i, tc.header, tc.headers, ip, tc.expected)
+		}
+	}
+}
+
+func TestIsTrustedPeer(t *testing.T) {
+	setTrusted(t, fiber.HeaderXForwardedFor, "10.0.0.0/8", "192.168.1.1", "::1")
+	for i, tc := range []struct {
+		addr    net.Addr
+		trusted bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.1.2.3")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.1")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.2")}, false},
+		{&net.TCPAddr{IP: net.ParseIP("::1")}, true},
+		{&net.UnixAddr{Name: "/run/seif.sock"}, false},
+	} {
+		if ok := IsTrustedPeer(tc.addr); ok != tc.trusted {
+			t.Errorf("%d: %s got %v", i, tc.addr, ok)
+		}
+	}
+
+	setTrusted(t, fiber.HeaderXForwardedFor, "unix")
+	if !IsTrustedPeer(&net.UnixAddr{Name: "/run/seif.sock"}) {
+		t.Error("unix: not trusted")
+	}
+	if IsTrustedPeer(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}) {
+		t.Error("tcp: trusted with unix only")
+	}
+}
+
+func TestInitInvalid(t *testing.T) {
+	trusted, trustUnix = nil, false
+	params.TrustedProxies = []string{"10.0.0.0/33"}
+	t.Cleanup(func() { params.TrustedProxies = nil })
+	if err := Init(); err == nil {
+		t.Error("invalid prefix accepted")
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package qr
+
+import (
+	"fmt"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QR codes of the links, rendered here so that no external service sees them
+
+const FormatPNG = "png"
+const FormatSVG = "svg"
+
+const DefaultSize = 256
+const MinSize = 64
+const MaxSize = 1024
+
+func newCode(content string) (*qrcode.QRCode, error) {
+	return qrcode.New(content, qrcode.Medium)
+}
+
+// A PNG of size x size pixels
+func PNG(content string, size int) ([]byte, error) {
+	q, err := newCode(content)
+	if err != nil {
+		return nil, err
+	}
+	return q.PNG(size)
+}
+
+// An SVG, with a module per unit and the given size in pixels
+func SVG(content string, size int) ([]byte, error) {
+	q, err := newCode(content)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := q.Bitmap()
+
+	var path strings.Builder
+	for y, row := range bitmap {
+		// A rectangle per run of dark modules
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	n := len(bitmap)
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`+"\n",
+		size, size, n, n, n, n, path.String())), nil
+}
+
+// For the terminal, with two modules per character
+func Terminal(content string) (string, error) {
+	q, err := newCode(content)
+	if err != nil {
+		return "", err
+	}
+	return q.ToSmallString(false), nil
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const link = "https://seif.example/?s=KEY&t=ID"
+
+func TestPNG(t *testing.T) {
+	for _, size := range []int{MinSize, DefaultSize, MaxSize} {
+		img, err := PNG(link, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := png.DecodeConfig(bytes.NewReader(img))
+		if err != nil || cfg.Width != size || cfg.Height != size {
+			t.Errorf("%d: got %+v, %v", size, cfg, err)
+		}
+	}
+}
+
+// The runs of the path, redrawn, must give back the code
+func TestSVG(t *testing.T) {
+	q, err := newCode(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bitmap := q.Bitmap()
+	n := len(bitmap)
+
+	img, err := SVG(link, DefaultSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := string(img)
+	header := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="256" height="256" viewBox="0 0 %d %d" `, n, n)
+	if !strings.HasPrefix(svg, header) || !strings.HasSuffix(svg, "</svg>\n") {
+		t.Fatalf("got %s", svg)
+	}
+
+	drawn := make([][]bool, n)
+	for y := range drawn {
+		drawn[y] = make([]bool, n)
+	}
+	path := regexp.MustCompile(`<path d="([^"]*)"`).FindStringSubmatch(svg)[1]
+	for _, run := range strings.Split(strings.TrimSuffix(path, "z"), "z") {
+		var x, y, w, w2 int
+		if _, err := fmt.Sscanf(run, "M%d %dh%dv1h-%d", &x, &y, &w, &w2); err != nil || w != w2 || w < 1 {
+			t.Fatalf("run %q: %v", run, err)
+		}
+		for i := x; i < x+w; i++ {
+			if drawn[y][i] {
+				t.Fatalf("run %q overlaps another", run)
+			}
+			drawn[y][i] = true
+		}
+	}
+	for y := range bitmap {
+		for x := range bitmap[y] {
+			if drawn[y][x] != bitmap[y][x] {
+				t.Fatalf("module %d,%d: drawn %v, expected %v", x, y, drawn[y][x], bitmap[y][x])
+			}
+		}
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	s, err := Terminal(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := newCode(link)
+	// Two rows of modules per line
+	if lines := strings.Count(s, "\n"); lines != (len(q.Bitmap())+1)/2 {
+		t.Errorf("got %d lines for %d modules", lines, len(q.Bitmap()))
+	}
+}
+
+func TestTooLong(t *testing.T) {
+	content := strings.Repeat("x", 4000)
+	if _, err := PNG(content, DefaultSize); err == nil {
+		t.Error("PNG: expected an error")
+	}
+	if _, err := SVG(content, DefaultSize); err == nil {
+		t.Error("SVG: expected an error")
+	}
+	if _, err := Terminal(content); err == nil {
+		t.Error("Terminal: expected an error")
+	}
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package secheaders
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"seif/params"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Value of -csp that computes the policy from the embedded UI
+const CSP_AUTO = "auto"
+
+// The bootstrap files that the UI loads, see frontend/index.html, where
+// they're also pinned by their SRI hash
+const (
+	bootstrapJs  = "https://cdn.jsdelivr.net/npm/bootstrap@5.3.2/dist/js/bootstrap.bundle.min.js"
+	bootstrapCss = "https://cdn.jsdelivr.net/npm/bootstrap@5.3.2/dist/css/bootstrap.min.css"
+)
+
+var csp string
+
+var inlineScriptRegexp = regexp.MustCompile(`(?is)<script([^>]*)>(.*?)</script>`)
+
+// Computes the CSP, if automatic, allowing the inline scripts of the HTML
+// files in static by their hash. There are no inline styles: the colors of
+// the theme come from /theme.css.
+func Init(static fs.FS) error {
+	csp = params.Csp
+	if csp != CSP_AUTO {
+		return nil
+	}
+
+	hashes, err := inlineScriptHashes(static)
+	if err != nil {
+		return fmt.Errorf("in computing the CSP: %w", err)
+	}
+
+	csp = strings.Join([]string{
+		"default-src 'self'",
+		"script-src 'self' " + bootstrapJs + strings.Join(hashes, ""),
+		"style-src 'self' " + bootstrapCss,
+		"img-src 'self' data:",
+		"connect-src 'self'",
+		"object-src 'none'",
+		"base-uri 'none'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+	}, "; ")
+	return nil
+}
+
+func inlineScriptHashes(static fs.FS) ([]string, error) {
+	var ret []string
+	err := fs.WalkDir(static, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".html") {
+			return err
+		}
+		html, err := fs.ReadFile(static, path)
+		if err != nil {
+			return err
+		}
+		for _, m := range inlineScriptRegexp.FindAllSubmatch(html, -1) {
+			if strings.Contains(strings.ToLower(string(m[1])), "src=") || len(m[2]) == 0 {
+				continue
+			}
+			sum := sha256.Sum256(m[2])
+			ret = append(ret, " 'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'")
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// Sets the security headers; an empty setting omits its header
+func Middleware(c *fiber.Ctx) error {
+	set := func(header, value string) {
+		if value != "" {
+			c.Set(header, value)
+		}
+	}
+
+	set(fiber.HeaderContentSecurityPolicy, csp)
+	set(fiber.HeaderReferrerPolicy, params.ReferrerPolicy)
+	set(fiber.HeaderXFrameOptions, params.FrameOptions)
+	set(fiber.HeaderPermissionsPolicy, params.PermissionsPolicy)
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+
+	// Browsers ignore it on plain HTTP anyway
+	if params.HstsMaxAge > 0 && c.Protocol() == "https" {
+		c.Set(fiber.HeaderStrictTransportSecurity, fmt.Sprintf("max-age=%d", params.HstsMaxAge))
+	}
+
+	if strings.HasPrefix(c.Path(), "/api/") {
+		set(fiber.HeaderCacheControl, params.ApiCacheControl)
+	}
+
+	return c.Next()
+}
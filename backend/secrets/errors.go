@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package secrets
+
+import (
+	"fmt"
+	"seif/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// An error of the operations on secrets, that each frontend (JSON API,
+// server-side pages) renders in its own way
+type Error struct {
+	Def        *utils.ErrorDef
+	Object     string
+	Err        error         // the cause, if any
+	RetryAfter time.Duration // when it's worth retrying, if set
+}
+
+func newError(def *utils.ErrorDef, object string, err error) *Error {
+	return &Error{Def: def, Object: object, Err: err}
+}
+
+// The message for the user, in the given language, without the cause
+func (e *Error) Message(lang string) string {
+	return e.Def.Message(lang, e.Object)
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message(utils.Languages[0]) + ": " + e.Err.Error()
+	}
+	return e.Message(utils.Languages[0])
+}
+
+// Sends the error as the JSON API does
+func (e *Error) Send(c *fiber.Ctx) error {
+	if e.RetryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, fmt.Sprint(int(e.RetryAfter.Seconds())+1))
+	}
+	var err *error
+	if e.Err != nil {
+		err = &e.Err
+	}
+	return utils.SendError(c, e.Def, e.Object, err)
+}
@@ -0,0 +1,283 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package secrets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"seif/audit"
+	"seif/crypton"
+	"seif/db_ops"
+	"seif/limiter"
+	"seif/metrics"
+	"seif/params"
+	"seif/payload"
+	"seif/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The operations on secrets, shared by the API and the server-side pages.
+// The request is used for auditing, quotas and logging.
+
+const SQL_PUT = `
+	INSERT INTO SECRETS (ID, SECRET, EXPIRY, TS, FORMAT, NOT_BEFORE, SEPARATE_KEY, FINGERPRINT)
+	VALUES ($1, $2, $3, CURRENT_TIMESTAMP, $4, $5, $6, $7)`
+const SQL_GET = "SELECT SECRET, FORMAT, NOT_BEFORE, SEPARATE_KEY FROM SECRETS WHERE ID = $1"
+const SQL_DEL = "DELETE FROM SECRETS WHERE ID = $1"
+const SQL_GET_STATUS = "SELECT NOT_BEFORE, SEPARATE_KEY, FINGERPRINT FROM SECRETS WHERE ID = $1"
+
+type Options struct {
+	// If in the future, the secret can't be revealed before then
+	NotBefore *time.Time
+	// The key must be given separately from the link: reveal requests with
+	// the key in the URL are refused
+	SeparateKey bool
+	// Whether to keep a fingerprint of the key, for the recipient to verify
+	Fingerprint bool
+}
+
+type Created struct {
+	Id          string
+	Key         string
+	Fingerprint string // if asked for
+}
+
+// Encrypts and stores a secret, for expiry days
+func Create(c *fiber.Ctx, s payload.Secret, expiry int, opts Options) (*Created, *Error) {
+	plain, format, err := payload.Encode(s)
+	if err != nil {
+		var verr *payload.ValidationError
+		if errors.As(err, &verr) {
+			return nil, newError(utils.FHE004, verr.Field, verr.Err)
+		}
+		return nil, newError(utils.FHE008, "encoding", err)
+	}
+
+	if len(plain) > params.MaxBytes {
+		return nil, newError(utils.FHE005, "", nil)
+	}
+
+	if expiry < 1 || expiry > params.MaxDays {
+		return nil, newError(utils.FHE006, fmt.Sprint(params.MaxDays), nil)
+	}
+
+	var nb *string
+	if notBefore := opts.NotBefore; notBefore != nil && notBefore.After(time.Now()) {
+		if notBefore.After(time.Now().AddDate(0, 0, params.MaxDelayDays)) {
+			return nil, newError(utils.FHE010, fmt.Sprint(params.MaxDelayDays), nil)
+		}
+		_nb := notBefore.UTC().Format(db_ops.TIME_FORMAT)
+		nb = &_nb
+	}
+
+	id, key, crypto, err := crypton.Encode(plain)
+	if err != nil {
+		return nil, newError(utils.FHE007, "", err)
+	}
+
+	ret := &Created{Id: crypton.Bs2str(id), Key: crypton.Bs2str(key)}
+	var fingerprint *string
+	if opts.Fingerprint {
+		ret.Fingerprint = crypton.Fingerprint(key)
+		fingerprint = &ret.Fingerprint
+	}
+
+	defer db_ops.BackupAsync()
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	tx, err := params.Db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, newError(utils.FHE002, "transaction", err)
+	}
+	defer tx.Rollback()
+
+	// Charged in the same transaction, so that a failed creation doesn't count
+	ok, retryAfter, err := limiter.CheckQuota(c, tx, len(crypto))
+	if err != nil {
+		return nil, newError(utils.FHE008, "quota check", err)
+	}
+	if !ok {
+		audit.Count(c, db_ops.AUDIT_RATE_LIMITED)
+		e := newError(utils.FHE015, fmt.Sprint(params.QuotaTokenBytes), nil)
+		e.RetryAfter = retryAfter
+		return nil, e
+	}
+
+	if _, err = tx.Exec(SQL_PUT, ret.Id, crypto, expiry, format, nb, opts.SeparateKey, fingerprint); err != nil {
+		return nil, newError(utils.FHE002, "secrets", err)
+	}
+
+	if err := db_ops.IncCounter(tx, db_ops.COUNTER_CREATED, 1); err != nil {
+		return nil, newError(utils.FHE002, "counters", err)
+	}
+
+	if err := db_ops.AppendAudit(tx, audit.Event(c, db_ops.AUDIT_CREATED, ret.Id)); err != nil {
+		return nil, newError(utils.FHE002, "audit log", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, newError(utils.FHE002, "transaction", err)
+	}
+	metrics.SecretsCreated.Inc()
+
+	return ret, nil
+}
+
+// Decrypts and deletes a secret. Returns nil if there's no such secret,
+// e.g. because it was already revealed or expired. keyInUrl tells whether
+// the key came in the URL, that secrets with a separate key refuse.
+func Reveal(c *fiber.Ctx, id, key string, keyInUrl bool) (*payload.Secret, *Error) {
+	idBs, err := crypton.Str2bs(id)
+	if err != nil {
+		return nil, newError(utils.FHE004, "id", err)
+	}
+	keyBs, err := crypton.Str2bs(key)
+	if err != nil {
+		return nil, newError(utils.FHE004, "key", err)
+	}
+
+	defer db_ops.BackupAsync()
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	// Failed attempts are audited after the transaction is rolled back
+	var failure string
+	defer func() {
+		if failure != "" {
+			audit.LogLocked(c, failure, id)
+		}
+	}()
+
+	tx, err := params.Db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, newError(utils.FHE002, "transaction", err)
+	}
+	defer tx.Rollback()
+
+	var secret []byte
+	var format int
+	var notBefore sql.NullString
+	var separateKey bool
+	err = tx.QueryRow(SQL_GET, id).Scan(&secret, &format, &notBefore, &separateKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newError(utils.FHE001, "secret", err)
+	}
+
+	// The secret is not burnt: the key may have leaked, but it's up to the
+	// sender to decide
+	if separateKey && keyInUrl {
+		failure = db_ops.AUDIT_KEY_IN_URL
+		return nil, newError(utils.FHE022, "", nil)
+	}
+
+	// Time-locked: don't even try to decrypt, and tell when it will open
+	if notBefore.Valid {
+		nb, err := time.Parse(db_ops.TIME_FORMAT, notBefore.String)
+		if err != nil {
+			return nil, newError(utils.FHE020, "reveal time", err)
+		}
+		if wait := time.Until(nb); wait > 0 {
+			failure = db_ops.AUDIT_REVEAL_LOCKED
+			e := newError(utils.FHE011, nb.Format(time.RFC3339), nil)
+			e.RetryAfter = wait
+			return nil, e
+		}
+	}
+
+	plaintxt, err := crypton.Decode(idBs, keyBs, secret)
+	if err != nil {
+		failure = db_ops.AUDIT_REVEAL_FAILED
+		metrics.DecryptFailures.Inc()
+		return nil, newError(utils.FHE021, "", err)
+	}
+
+	decoded, err := payload.Decode(plaintxt, format)
+	if err != nil {
+		return nil, newError(utils.FHE008, "decoding", err)
+	}
+
+	if _, err := tx.Exec(SQL_DEL, id); err != nil {
+		return nil, newError(utils.FHE009, "secret", err)
+	}
+
+	if err := db_ops.IncCounter(tx, db_ops.COUNTER_REVEALED, 1); err != nil {
+		return nil, newError(utils.FHE002, "counters", err)
+	}
+
+	if err := db_ops.AppendAudit(tx, audit.Event(c, db_ops.AUDIT_REVEALED, id)); err != nil {
+		return nil, newError(utils.FHE002, "audit log", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, newError(utils.FHE009, "transaction", err)
+	}
+	metrics.SecretsRevealed.Inc()
+
+	return decoded, nil
+}
+
+// A scheduled secret is pristine, but time-locked until NotBefore
+type Status struct {
+	Pristine    bool
+	Scheduled   bool
+	NotBefore   time.Time
+	SeparateKey bool
+	Fingerprint string
+}
+
+// Whether a secret can (still) be revealed, without revealing it
+func GetStatus(c *fiber.Ctx, id string) (*Status, *Error) {
+	params.Lock.Lock()
+	defer params.Lock.Unlock()
+
+	ret := &Status{}
+	var notBefore, fingerprint sql.NullString
+	err := params.Db.QueryRow(SQL_GET_STATUS, id).Scan(&notBefore, &ret.SeparateKey, &fingerprint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, newError(utils.FHE001, "secret", err)
+	}
+
+	// Only for existing secrets, or probing random IDs would fill the log
+	audit.LogLocked(c, db_ops.AUDIT_STATUS, id)
+
+	ret.Pristine = true
+	ret.Fingerprint = fingerprint.String
+	if notBefore.Valid {
+		nb, err := time.Parse(db_ops.TIME_FORMAT, notBefore.String)
+		if err != nil {
+			return nil, newError(utils.FHE020, "reveal time", err)
+		}
+		if time.Until(nb) > 0 {
+			ret.Scheduled = true
+			ret.NotBefore = nb
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package theme
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"seif/params"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Branding of the UI, from the JSON file in -theme; all fields are optional,
+// and the UI keeps its defaults for those that are missing
+type Theme struct {
+	ProductName  string `json:"product_name,omitempty"`
+	Tagline      string `json:"tagline,omitempty"`
+	Colors       Colors `json:"colors"`
+	Footer       string `json:"footer,omitempty"`
+	LegalUrl     string `json:"legal_url,omitempty"`    // link to the legal notice
+	Instructions string `json:"instructions,omitempty"` // shown above the form to create secrets
+}
+
+// CSS colors, for the header and the main buttons
+type Colors struct {
+	Primary     string `json:"primary,omitempty"`
+	PrimaryText string `json:"primary_text,omitempty"`
+}
+
+// The theme in use, nil if there's none
+var Current *Theme
+
+// The colors of the theme as a stylesheet, served as /theme.css so that the
+// pages need no inline styles, that the CSP forbids
+var css string
+
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+|(rgb|hsl)a?\([0-9., %]+\))$`)
+
+// Loads the theme, if configured
+func Init() error {
+	if params.ThemeFile == "" {
+		return nil
+	}
+	bs, err := os.ReadFile(params.ThemeFile)
+	if err != nil {
+		return fmt.Errorf("in reading the theme: %w", err)
+	}
+	t, err := parse(bs)
+	if err != nil {
+		return fmt.Errorf("in theme %s: %w", params.ThemeFile, err)
+	}
+	Current = t
+	css = stylesheet(t.Colors)
+	return nil
+}
+
+// Serves the stylesheet of the theme, empty if there's none
+func Css(c *fiber.Ctx) error {
+	c.Type("css", "utf-8")
+	return c.Status(fiber.StatusOK).SendString(css)
+}
+
+// The pages mark the header with seif-primary and seif-primary-text, and the
+// main buttons with seif-btn
+func stylesheet(colors Colors) string {
+	var sb strings.Builder
+	if colors.Primary != "" {
+		fmt.Fprintf(&sb, ".seif-primary { background-color: %s !important; }\n", colors.Primary)
+		fmt.Fprintf(&sb, ".seif-btn { --bs-btn-bg: %[1]s; --bs-btn-border-color: %[1]s; --bs-btn-hover-bg: %[1]s; --bs-btn-hover-border-color: %[1]s; }\n", colors.Primary)
+		if colors.PrimaryText != "" {
+			fmt.Fprintf(&sb, ".seif-btn { --bs-btn-color: %[1]s; --bs-btn-hover-color: %[1]s; }\n", colors.PrimaryText)
+		}
+	}
+	if colors.PrimaryText != "" {
+		fmt.Fprintf(&sb, ".seif-primary-text { color: %s !important; }\n", colors.PrimaryText)
+	}
+	return sb.String()
+}
+
+func parse(bs []byte) (*Theme, error) {
+	t := new(Theme)
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(t); err != nil {
+		return nil, err
+	}
+
+	for name, color := range map[string]string{"primary": t.Colors.Primary, "primary_text": t.Colors.PrimaryText} {
+		if color != "" && !cssColor.MatchString(color) {
+			return nil, fmt.Errorf("colors.%s: not a CSS color: %s", name, color)
+		}
+	}
+	if t.LegalUrl != "" {
+		u, err := url.Parse(t.LegalUrl)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "") {
+			return nil, fmt.Errorf("legal_url: not a web link: %s", t.LegalUrl)
+		}
+	}
+	return t, nil
+}
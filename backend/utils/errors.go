@@ -18,12 +18,156 @@
  */
 package utils
 
-var FHE001 = "cannot read from %s"
-var FHE002 = "cannot write to %s"
-var FHE003 = "residual error on resultset"
-var FHE004 = "%s is malformed"
-var FHE005 = "secret is too long"
-var FHE006 = "invalid expiry, must be between 1 and %s days"
-var FHE007 = "cannot generate random key"
-var FHE008 = "%s failed"
-var FHE009 = "cannot delete %s"
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// An entry of the error catalog. Id and Name are stable, for clients to
+// rely upon; the messages are templates by language, with a %s for the
+// object of the error if Param names it.
+type ErrorDef struct {
+	Id       string
+	Name     string
+	Status   int
+	Param    string
+	Messages map[string]string
+}
+
+type msgs map[string]string
+
+// The languages of the messages; the first is the default
+var Languages = []string{"en", "it"}
+
+// All the errors, by Id
+var Catalog = map[string]*ErrorDef{}
+
+func def(id, name string, status int, param string, messages msgs) *ErrorDef {
+	d := &ErrorDef{Id: id, Name: name, Status: status, Param: param, Messages: messages}
+	Catalog[id] = d
+	return d
+}
+
+var FHE001 = def("FHE001", "read_failed", fiber.StatusInternalServerError, "source", msgs{
+	"en": "cannot read from %s",
+	"it": "impossibile leggere da %s",
+})
+var FHE002 = def("FHE002", "write_failed", fiber.StatusInternalServerError, "target", msgs{
+	"en": "cannot write to %s",
+	"it": "impossibile scrivere su %s",
+})
+var FHE003 = def("FHE003", "resultset_error", fiber.StatusInternalServerError, "", msgs{
+	"en": "residual error on resultset",
+	"it": "errore residuo nel resultset",
+})
+var FHE004 = def("FHE004", "malformed", fiber.StatusBadRequest, "field", msgs{
+	"en": "%s is malformed",
+	"it": "%s non è valido",
+})
+var FHE005 = def("FHE005", "secret_too_long", fiber.StatusBadRequest, "", msgs{
+	"en": "secret is too long",
+	"it": "il segreto è troppo lungo",
+})
+var FHE006 = def("FHE006", "invalid_expiry", fiber.StatusBadRequest, "max_days", msgs{
+	"en": "invalid expiry, must be between 1 and %s days",
+	"it": "scadenza non valida, deve essere tra 1 e %s giorni",
+})
+var FHE007 = def("FHE007", "random_failed", fiber.StatusInternalServerError, "", msgs{
+	"en": "cannot generate random key",
+	"it": "impossibile generare una chiave casuale",
+})
+var FHE008 = def("FHE008", "operation_failed", fiber.StatusInternalServerError, "operation", msgs{
+	"en": "%s failed",
+	"it": "operazione non riuscita: %s",
+})
+var FHE009 = def("FHE009", "delete_failed", fiber.StatusInternalServerError, "target", msgs{
+	"en": "cannot delete %s",
+	"it": "impossibile eliminare %s",
+})
+var FHE010 = def("FHE010", "invalid_not_before", fiber.StatusBadRequest, "max_days", msgs{
+	"en": "invalid reveal time, must be within %s days",
+	"it": "data di apertura non valida, deve essere entro %s giorni",
+})
+var FHE011 = def("FHE011", "secret_locked", fiber.StatusLocked, "not_before", msgs{
+	"en": "secret is locked until %s",
+	"it": "il segreto è bloccato fino a %s",
+})
+var FHE012 = def("FHE012", "authentication_required", fiber.StatusUnauthorized, "", msgs{
+	"en": "authentication required",
+	"it": "autenticazione richiesta",
+})
+var FHE013 = def("FHE013", "login_failed", fiber.StatusUnauthorized, "", msgs{
+	"en": "login failed",
+	"it": "accesso non riuscito",
+})
+var FHE014 = def("FHE014", "rate_limited", fiber.StatusTooManyRequests, "", msgs{
+	"en": "too many requests",
+	"it": "troppe richieste",
+})
+var FHE015 = def("FHE015", "quota_exceeded", fiber.StatusTooManyRequests, "quota_bytes", msgs{
+	"en": "daily quota of %s bytes exceeded",
+	"it": "superata la quota giornaliera di %s byte",
+})
+var FHE016 = def("FHE016", "forbidden", fiber.StatusForbidden, "", msgs{
+	"en": "not authorized",
+	"it": "non autorizzato",
+})
+var FHE017 = def("FHE017", "not_found", fiber.StatusNotFound, "resource", msgs{
+	"en": "%s not found",
+	"it": "%s non trovato",
+})
+var FHE018 = def("FHE018", "preview_blocked", fiber.StatusForbidden, "", msgs{
+	"en": "link previews and scanners cannot open secrets",
+	"it": "le anteprime dei link e gli scanner non possono aprire i segreti",
+})
+var FHE019 = def("FHE019", "nonce_required", fiber.StatusForbidden, "", msgs{
+	"en": "missing or expired reveal nonce, please retry",
+	"it": "nonce di apertura mancante o scaduto, riprova",
+})
+var FHE020 = def("FHE020", "corrupted_data", fiber.StatusInternalServerError, "field", msgs{
+	"en": "%s is corrupted",
+	"it": "%s è danneggiato",
+})
+var FHE021 = def("FHE021", "wrong_key", fiber.StatusBadRequest, "", msgs{
+	"en": "cannot decrypt the secret, the key is wrong",
+	"it": "impossibile decifrare il segreto, la chiave è errata",
+})
+var FHE022 = def("FHE022", "key_in_url", fiber.StatusBadRequest, "", msgs{
+	"en": "the key of this secret must be given separately, not in the link",
+	"it": "la chiave di questo segreto va fornita a parte, non nel link",
+})
+
+// The message in the given language, with the object of the error
+func (d *ErrorDef) Message(lang, obj string) string {
+	msg, ok := d.Messages[lang]
+	if !ok {
+		msg = d.Messages[Languages[0]]
+	}
+	if d.Param != "" {
+		return strings.Replace(msg, "%s", obj, 1)
+	}
+	return msg
+}
+
+// The preferred language of the request among Languages, by Accept-Language
+func Lang(c *fiber.Ctx) string {
+	best, bestQ := Languages[0], 0.0
+	for _, part := range strings.Split(c.Get(fiber.HeaderAcceptLanguage), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if slices.Contains(Languages, base) && q > bestQ {
+			best, bestQ = base, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright (C) 2024- Germano Rizzo
+ *
+ * This file is part of Seif.
+ *
+ * Seif is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Seif is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Seif.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package utils
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	names := map[string]string{}
+	for id, d := range Catalog {
+		if d.Id != id {
+			t.Errorf("%s: registered as %s", d.Id, id)
+		}
+		if other, ok := names[d.Name]; ok {
+			t.Errorf("%s: name %s already used by %s", id, d.Name, other)
+		}
+		names[d.Name] = id
+		if d.Status < 400 {
+			t.Errorf("%s: status %d is not an error", id, d.Status)
+		}
+		for _, lang := range Languages {
+			msg, ok := d.Messages[lang]
+			if !ok {
+				t.Errorf("%s: no message in %s", id, lang)
+			}
+			if n := strings.Count(msg, "%s"); (d.Param != "" && n != 1) || (d.Param == "" && n != 0) {
+				t.Errorf("%s: message in %s doesn't match param %q", id, lang, d.Param)
+			}
+		}
+		if len(d.Messages) != len(Languages) {
+			t.Errorf("%s: messages in languages not listed in Languages", id)
+		}
+	}
+}
+
+// The statuses that handlers may set by themselves; errors must go
+// through the catalog
+var successStatuses = map[string]bool{
+	"StatusOK":                true,
+	"StatusCreated":           true,
+	"StatusNoContent":         true,
+	"StatusFound":             true,
+	"StatusSeeOther":          true,
+	"StatusPermanentRedirect": true,
+}
+
+var fheLiteral = regexp.MustCompile(`FHE\d{3}`)
+
+// Every error path uses an entry of the catalog: no ids in literals, no
+// error statuses set by hand
+func TestErrorPathsUseCatalog(t *testing.T) {
+	fset := token.NewFileSet()
+	checked := 0
+	err := filepath.WalkDir("..", func(path string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		catalogFile := filepath.ToSlash(path) == "../utils/errors.go"
+		// Only forwards the entries chosen by its callers
+		plumbing := filepath.ToSlash(path) == "../secrets/errors.go"
+		handler := strings.HasPrefix(filepath.ToSlash(path), "../handlers/") &&
+			// Probes report their checks, not errors
+			!strings.HasPrefix(filepath.ToSlash(path), "../handlers/health/")
+
+		checkDef := func(what string, e ast.Expr) {
+			checked++
+			if plumbing {
+				return
+			}
+			sel, ok := e.(*ast.SelectorExpr)
+			if ok && isIdent(sel.X, "utils") && Catalog[sel.Sel.Name] != nil {
+				return
+			}
+			if id, ok := e.(*ast.Ident); ok && f.Name.Name == "utils" && Catalog[id.Name] != nil {
+				return
+			}
+			t.Errorf("%s: %s without a catalog entry", fset.Position(e.Pos()), what)
+		}
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.BasicLit:
+				if n.Kind == token.STRING && !catalogFile && fheLiteral.MatchString(n.Value) {
+					t.Errorf("%s: error id in a literal, use the catalog", fset.Position(n.Pos()))
+				}
+			case *ast.CallExpr:
+				switch {
+				case isCall(n, "utils", "SendError") && len(n.Args) > 1:
+					checkDef("SendError", n.Args[1])
+				case isIdent(n.Fun, "newError") && len(n.Args) > 0:
+					checkDef("newError", n.Args[0])
+				case handler && (isMethod(n, "Status") || isMethod(n, "SendStatus") || isIdent(n.Fun, "render")):
+					for _, arg := range n.Args {
+						if sel, ok := arg.(*ast.SelectorExpr); ok && isIdent(sel.X, "fiber") &&
+							strings.HasPrefix(sel.Sel.Name, "Status") && !successStatuses[sel.Sel.Name] {
+							t.Errorf("%s: %s set by hand, use the catalog", fset.Position(arg.Pos()), sel.Sel.Name)
+						}
+					}
+				}
+			case *ast.CompositeLit:
+				if isType(n.Type, "secrets", "Error") || (f.Name.Name == "secrets" && isIdent(n.Type, "Error")) {
+					found := false
+					for _, elt := range n.Elts {
+						if kv, ok := elt.(*ast.KeyValueExpr); ok && isIdent(kv.Key, "Def") {
+							checkDef("secrets.Error", kv.Value)
+							found = true
+						}
+					}
+					if !found {
+						t.Errorf("%s: secrets.Error without a catalog entry", fset.Position(n.Pos()))
+					}
+				}
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked == 0 {
+		t.Fatal("no error paths found")
+	}
+}
+
+func isIdent(e ast.Expr, name string) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == name
+}
+
+func isType(e ast.Expr, pkg, name string) bool {
+	sel, ok := e.(*ast.SelectorExpr)
+	return ok && isIdent(sel.X, pkg) && sel.Sel.Name == name
+}
+
+func isCall(c *ast.CallExpr, pkg, name string) bool {
+	return isType(c.Fun, pkg, name)
+}
+
+func isMethod(c *ast.CallExpr, name string) bool {
+	sel, ok := c.Fun.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == name
+}
@@ -19,15 +19,20 @@
 package utils
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"seif/logging"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 func Abort(msg string, a ...any) {
-	fmt.Fprintf(os.Stderr, "FATAL: %s\n", fmt.Sprintf(msg, a...))
+	slog.Error("FATAL: " + fmt.Sprintf(msg, a...))
 	os.Exit(-1)
 }
 
@@ -36,26 +41,84 @@ func FileExists(filePath string) bool {
 	return !os.IsNotExist(err)
 }
 
+// Like time.ParseDuration, but also accepts days, as "3d"
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration \"%s\"", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Code is the stable Id in the catalog, Object and Error are kept for the
+// clients that predate it
 type errorr struct {
-	Code   string  `json:"code"`
-	Object string  `json:"object"`
-	Error  *string `json:"error"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Object  string            `json:"object"`
+	Details map[string]string `json:"details,omitempty"`
+	Error   *string           `json:"error"`
 }
 
-func SendError(c *fiber.Ctx, status int, errCode string, obj string, err *error) error {
+// API v2 errors have the stable Id and name, and the message for the user;
+// the cause, if any, is in the details
+type errorV2 struct {
+	Error struct {
+		Id      string            `json:"id"`
+		Code    string            `json:"code"`
+		Message string            `json:"message"`
+		Details map[string]string `json:"details,omitempty"`
+	} `json:"error"`
+}
+
+const ApiV2Prefix = "/api/v2/"
+
+// Sends an error of the catalog, with the status it defines and the
+// message in the language of the request
+func SendError(c *fiber.Ctx, d *ErrorDef, obj string, err *error) error {
 	var errString *string
 	if err != nil {
 		_errString := (*err).Error()
 		errString = &_errString
 	}
-	e := errorr{
-		Code:   errCode,
-		Object: obj,
-		Error:  errString,
+	details := map[string]string{}
+	if d.Param != "" && obj != "" {
+		details[d.Param] = obj
 	}
 
-	str, _ := json.Marshal(e)
-	fmt.Fprintf(os.Stderr, "%s\n", str)
+	level := slog.LevelWarn
+	if d.Status >= fiber.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	logger := logging.FromCtx(c)
+	if errString != nil {
+		logger = logger.With("error", *errString)
+	}
+	logger.Log(context.Background(), level, "request failed", "code", d.Id, "object", obj, "status", d.Status)
+
+	msg := d.Message(Lang(c), obj)
+	if strings.HasPrefix(c.Path(), ApiV2Prefix) {
+		var e errorV2
+		e.Error.Id = d.Id
+		e.Error.Code = d.Name
+		e.Error.Message = msg
+		if errString != nil {
+			details["cause"] = *errString
+		}
+		if len(details) > 0 {
+			e.Error.Details = details
+		}
+		c.JSON(e)
+		return c.SendStatus(d.Status)
+	}
+
+	e := errorr{Code: d.Id, Message: msg, Object: obj, Error: errString}
+	if len(details) > 0 {
+		e.Details = details
+	}
 	c.JSON(e)
-	return c.SendStatus(status)
+	return c.SendStatus(d.Status)
 }